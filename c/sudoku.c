@@ -1,7 +1,9 @@
 #include <stdio.h>
 #include <stdbool.h>
+#include <string.h>
+#include "sudoku.h"
 
-bool is_valid(int board[9][9], int row, int col, int num) {
+bool is_valid(int board[9][9], int row, int col, int num, int regions) {
     // Check if we find the same num in the similar row
     for (int x = 0; x < 9; x++) {
         if (board[row][x] == num) {
@@ -26,17 +28,62 @@ bool is_valid(int board[9][9], int row, int col, int num) {
             }
         }
     }
+
+    // Check the main diagonals if the variant uses them
+    if (regions & SUDOKU_REGION_DIAGONAL) {
+        for (int x = 0; x < 9; x++) {
+            if ((row == col && board[x][x] == num) || (row + col == 8 && board[x][8 - x] == num)) {
+                return false;
+            }
+        }
+    }
+
+    // Check the 3*3 window around rows/columns 1-3 and 5-7 if the variant uses them
+    if ((regions & SUDOKU_REGION_WINDOKU) && row % 4 != 0 && col % 4 != 0) {
+        int window_row = row - (row - 1) % 4;
+        int window_col = col - (col - 1) % 4;
+        for (int i = 0; i < 3; i++) {
+            for (int j = 0; j < 3; j++) {
+                if (board[i + window_row][j + window_col] == num) {
+                    return false;
+                }
+            }
+        }
+    }
+
+    // Check the cells a knight's move away if the variant forbids repeats there
+    static const int knight_moves[8][2] = {{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}};
+    if (regions & SUDOKU_RULE_ANTI_KNIGHT) {
+        for (int m = 0; m < 8; m++) {
+            int r = row + knight_moves[m][0], c = col + knight_moves[m][1];
+            if (r >= 0 && r < 9 && c >= 0 && c < 9 && board[r][c] == num) {
+                return false;
+            }
+        }
+    }
+
+    // Check the diagonal neighbours if the variant forbids repeats a king's move away;
+    // the orthogonal ones share a row or column already
+    static const int king_moves[4][2] = {{-1, -1}, {-1, 1}, {1, -1}, {1, 1}};
+    if (regions & SUDOKU_RULE_ANTI_KING) {
+        for (int m = 0; m < 4; m++) {
+            int r = row + king_moves[m][0], c = col + king_moves[m][1];
+            if (r >= 0 && r < 9 && c >= 0 && c < 9 && board[r][c] == num) {
+                return false;
+            }
+        }
+    }
     return true;
 }
 
-void fill_naked_singles(int board[9][9]) {
+void fill_naked_singles(int board[9][9], int regions) {
     for (int i = 0; i < 9; i++) {
         for (int j = 0; j < 9; j++) {
             if (board[i][j] == 0) {
                 int possible_values[9] = {0};
                 int count = 0;
                 for (int num = 1; num <= 9; num++) {
-                    if (is_valid(board, i, j, num)) {
+                    if (is_valid(board, i, j, num, regions)) {
                         possible_values[count] = num;
                         count++;
                     }
@@ -49,16 +96,16 @@ void fill_naked_singles(int board[9][9]) {
         }
     }
 }
-void fill_unique_candidates(int board[9][9]) {
+void fill_unique_candidates(int board[9][9], int regions) {
     for (int i = 0; i < 9; i++) {
         for (int j = 0; j < 9; j++) {
             if (board[i][j] == 0) {
                 for (int num = 1; num <= 9; num++) {
-                    if (is_valid(board, i, j, num)) {
+                    if (is_valid(board, i, j, num, regions)) {
                         // Check rows
                         int row_count = 0;
                         for (int k = 0; k < 9; k++) {
-                            if (is_valid(board, i, k, num)) {
+                            if (is_valid(board, i, k, num, regions)) {
                                 row_count++;
                             }
                         }
@@ -70,7 +117,7 @@ void fill_unique_candidates(int board[9][9]) {
                         // Check columns
                         int col_count = 0;
                         for (int k = 0; k < 9; k++) {
-                            if (is_valid(board, k, j, num)) {
+                            if (is_valid(board, k, j, num, regions)) {
                                 col_count++;
                             }
                         }
@@ -85,7 +132,7 @@ void fill_unique_candidates(int board[9][9]) {
                         int box_count = 0;
                         for (int k = 0; k < 3; k++) {
                             for (int l = 0; l < 3; l++) {
-                                if (is_valid(board, start_row + k, start_col + l, num)) {
+                                if (is_valid(board, start_row + k, start_col + l, num, regions)) {
                                     box_count++;
                                 }
                             }
@@ -101,14 +148,14 @@ void fill_unique_candidates(int board[9][9]) {
     }
 }
     
-bool solve_sudoku_recursive(int board[9][9]) {
+bool solve_sudoku_recursive(int board[9][9], int regions) {
     for (int i = 0; i < 9; i++) {
         for (int j = 0; j < 9; j++) {
             if (board[i][j] == 0) {
                 for (int num = 1; num <= 9; num++) {
-                    if (is_valid(board, i, j, num)) {
+                    if (is_valid(board, i, j, num, regions)) {
                         board[i][j] = num;
-                        if (solve_sudoku_recursive(board)) {
+                        if (solve_sudoku_recursive(board, regions)) {
                             return true;
                         }
                         board[i][j] = 0;
@@ -122,22 +169,26 @@ bool solve_sudoku_recursive(int board[9][9]) {
     return true;
 }
 
-bool solve_sudoku(int *board) {
+bool solve_sudoku_regions(int *board, int regions) {
     int (*board_2d)[9] = (int (*)[9])board;
-    fill_naked_singles((int (*)[9])board);
-    fill_unique_candidates((int (*)[9])board);
-    return solve_sudoku_recursive((int (*)[9])board);
+    fill_naked_singles(board_2d, regions);
+    fill_unique_candidates(board_2d, regions);
+    return solve_sudoku_recursive(board_2d, regions);
+}
+
+bool solve_sudoku(int *board) {
+    return solve_sudoku_regions(board, 0);
 }
 
-int count_solutions_recursive(int board[9][9]) {
+int count_solutions_recursive(int board[9][9], int regions) {
     int count = 0;
     for (int i = 0; i < 9; i++) {
         for (int j = 0; j < 9; j++) {
             if (board[i][j] == 0) {
                 for (int num = 1; num <= 9; num++) {
-                    if (is_valid(board, i, j, num)) {
+                    if (is_valid(board, i, j, num, regions)) {
                         board[i][j] = num;
-                        count += count_solutions_recursive(board);
+                        count += count_solutions_recursive(board, regions);
                         board[i][j] = 0;
                     }
                 }
@@ -148,7 +199,87 @@ int count_solutions_recursive(int board[9][9]) {
     return 1; // if we reach this point, it means we've found a valid solution
 }
 
-int count_solutions(int *board) {
+int count_solutions_regions(int *board, int regions) {
     int (*board_2d)[9] = (int (*)[9])board;
-    return count_solutions_recursive(board_2d);
+    return count_solutions_recursive(board_2d, regions);
+}
+
+int count_solutions(int *board) {
+    return count_solutions_regions(board, 0);
+}
+
+void sudoku_search_init(sudoku_search *search, const int *board, int regions) {
+    memset(search, 0, sizeof(*search));
+    memcpy(search->board, board, sizeof(search->board));
+    search->regions = regions;
+
+    // A given that clashes with another leaves nothing to search
+    int (*board_2d)[9] = (int (*)[9])search->board;
+    for (int cell = 0; cell < 81; cell++) {
+        int num = search->board[cell];
+        if (num == 0) {
+            search->empty[search->empty_count++] = cell;
+            continue;
+        }
+        search->board[cell] = 0;
+        bool valid = num >= 1 && num <= 9 && is_valid(board_2d, cell / 9, cell % 9, num, regions);
+        search->board[cell] = num;
+        if (!valid) {
+            search->finished = true;
+            return;
+        }
+    }
+}
+
+int sudoku_search_run(sudoku_search *search, int max_solutions, long long max_nodes) {
+    int (*board_2d)[9] = (int (*)[9])search->board;
+    long long nodes = 0;
+    while (!search->finished) {
+        if (max_solutions > 0 && search->solutions >= max_solutions) {
+            break;
+        }
+        if (max_nodes > 0 && nodes >= max_nodes) {
+            break;
+        }
+
+        // Every empty cell is filled: record the solution and backtrack
+        if (search->depth == search->empty_count) {
+            if (search->solutions == 0) {
+                memcpy(search->solution, search->board, sizeof(search->solution));
+            }
+            search->solutions++;
+            search->depth--;
+            if (search->depth < 0) {
+                search->finished = true;
+            }
+            continue;
+        }
+
+        // Try the next digit in the cell at this depth
+        int cell = search->empty[search->depth];
+        int num = search->board[cell] + 1;
+        search->board[cell] = 0;
+        while (num <= 9 && !is_valid(board_2d, cell / 9, cell % 9, num, search->regions)) {
+            num++;
+        }
+        if (num <= 9) {
+            search->board[cell] = num;
+            search->depth++;
+            search->nodes++;
+            nodes++;
+        } else if (--search->depth < 0) {
+            search->finished = true;
+        }
+    }
+    return search->solutions;
+}
+
+int count_solutions_limit(int *board, int regions, int max_solutions, long long max_nodes) {
+    sudoku_search search;
+    sudoku_search_init(&search, board, regions);
+    int count = sudoku_search_run(&search, max_solutions, max_nodes);
+    if (!search.finished && (max_solutions <= 0 || count < max_solutions)) {
+        return -1;
+    }
+    return count;
 }
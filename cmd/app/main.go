@@ -21,6 +21,7 @@ func main() {
 	port := flag.String("port", "8081", "Port to run the server on")
 	logLevel := flag.String("log-level", "info", "Log level (error, warn, info, debug, trace)")
 	logToFile := flag.Bool("log-to-file", false, "Whether to log to a file in addition to stdout")
+	solverName := flag.String("solver", "native", fmt.Sprintf("Solver backend to use %v", sudoku.SolverNames()))
 
 	// Show usage if help flag is present
 	flag.Usage = func() {
@@ -35,6 +36,7 @@ func main() {
 	log.Printf("  - port: %s", *port)
 	log.Printf("  - log-level: %s", *logLevel)
 	log.Printf("  - log-to-file: %v", *logToFile)
+	log.Printf("  - solver: %s", *solverName)
 
 	// Initialize the logging system
 	log.Println("Initializing logging system...")
@@ -62,6 +64,10 @@ func main() {
 
 	// Initialize sudoku solver
 	utils.Log(utils.LogLevelInfo, "Initializing Sudoku solver...")
+	if err := sudoku.SetSolver(*solverName); err != nil {
+		utils.Log(utils.LogLevelError, "Error selecting solver: %v", err)
+		os.Exit(1)
+	}
 	sudoku.InitSolver()
 
 	// Setup routes
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -12,6 +14,17 @@ import (
 	"github.com/danjones/sudoku_dj/internal/utils"
 )
 
+// requestLimits is the hard ceiling on solver work done for a single request,
+// so that a nearly empty or contradictory grid can't pin a CPU
+var requestLimits = sudoku.SearchLimits{
+	MaxNodes: 2000000,
+	Timeout:  5 * time.Second,
+}
+
+// generateTimeout is the hard ceiling on generating a puzzle for a single
+// request, whatever the options
+const generateTimeout = 30 * time.Second
+
 // EnableCORS adds CORS headers to support cross-origin requests
 func EnableCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -61,6 +74,18 @@ func HandleSudokuRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle requests to /sudoku/analyze, which works on any grid sent to it
+	if pathParts[2] == "analyze" && (len(pathParts) == 3 || pathParts[3] == "") {
+		HandleAnalyze(w, r)
+		return
+	}
+
+	// Handle requests to /sudoku/{uuid}/{action}
+	if len(pathParts) > 3 && pathParts[3] != "" {
+		HandlePuzzleAction(w, r, pathParts[2], pathParts[3])
+		return
+	}
+
 	// Handle requests to /sudoku/{uuid}
 	HandlePuzzleByUUID(w, r, pathParts[2])
 }
@@ -77,6 +102,77 @@ func HandleGenerateSudokuPuzzle(w http.ResponseWriter, r *http.Request) {
 
 	difficulty := utils.ParseDifficulty(r.FormValue("difficulty"))
 	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+	seed, err := utils.ParseSeed(r.FormValue("seed"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid seed: %v", err)
+		http.Error(w, "Invalid seed, expected an integer", http.StatusBadRequest)
+		return
+	}
+	symmetry, err := sudoku.ParseSymmetry(r.FormValue("symmetry"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid symmetry: %v", err)
+		http.Error(w, "Invalid symmetry, expected rotational, diagonal, mirror or rotational90", http.StatusBadRequest)
+		return
+	}
+	size, err := sudoku.ParseSize(r.FormValue("size"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid size: %v", err)
+		http.Error(w, "Invalid size, expected 4, 6, 9, 12, 16 or 25", http.StatusBadRequest)
+		return
+	}
+	fewestClues, mostClues := sudoku.ClueLimits(size)
+	minClues, maxClues, err := utils.ParseClueRange(r.FormValue("clues"), fewestClues, mostClues)
+	if err == nil && r.FormValue("max_clues") != "" {
+		maxClues, err = utils.ParseMaxClues(r.FormValue("max_clues"), fewestClues, mostClues)
+	}
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid clue count: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid clue count, expected a number or range between %d and %d", fewestClues, mostClues),
+			http.StatusBadRequest)
+		return
+	}
+	mask, err := sudoku.ParseMask(r.FormValue("mask"), size)
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid clue mask: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid clue mask: %v", err), http.StatusBadRequest)
+		return
+	}
+	technique, err := sudoku.ParseTechnique(r.FormValue("technique"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid technique: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid technique: %v", err), http.StatusBadRequest)
+		return
+	}
+	if mask != nil && (symmetry != sudoku.SymmetryNone || minClues > 0 || maxClues > 0 || utils.ParseFlag(r.FormValue("minimal"))) {
+		utils.Log(utils.LogLevelError, "Clue mask combined with other clue options")
+		http.Error(w, "A clue mask can't be combined with symmetry, minimal or clue counts", http.StatusBadRequest)
+		return
+	}
+	regions, err := sudoku.ParseExtraRegions(r.FormValue("regions"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid regions: %v", err)
+		http.Error(w, "Invalid regions, expected diagonal and/or windoku", http.StatusBadRequest)
+		return
+	}
+	killer := utils.ParseFlag(r.FormValue("killer"))
+	jigsaw := utils.ParseFlag(r.FormValue("jigsaw"))
+	if size != sudoku.ClassicSize && (killer || jigsaw || regions != nil) {
+		utils.Log(utils.LogLevelError, "Variant rules asked for on a %dx%d grid", size, size)
+		http.Error(w, "killer, jigsaw and regions need a 9x9 grid", http.StatusBadRequest)
+		return
+	}
+	samurai := utils.ParseFlag(r.FormValue("samurai"))
+	if samurai && (size != sudoku.ClassicSize || killer || jigsaw || regions != nil || mask != nil || minClues > 0 || maxClues > 0) {
+		utils.Log(utils.LogLevelError, "samurai combined with other variant or clue options")
+		http.Error(w, "samurai can't be combined with size, killer, jigsaw, regions, a clue mask or clue counts", http.StatusBadRequest)
+		return
+	}
+	noGivens := utils.ParseFlag(r.FormValue("no_givens"))
+	if noGivens && (!killer || mask != nil || minClues > 0 || maxClues > 0 || utils.ParseFlag(r.FormValue("minimal"))) {
+		utils.Log(utils.LogLevelError, "no_givens combined with other clue options")
+		http.Error(w, "no_givens needs killer and can't be combined with a clue mask, minimal or clue counts", http.StatusBadRequest)
+		return
+	}
 
 	// Set log level if provided
 	if logLevel != "" {
@@ -88,7 +184,32 @@ func HandleGenerateSudokuPuzzle(w http.ResponseWriter, r *http.Request) {
 	utils.Log(utils.LogLevelInfo, "Generating new puzzle with difficulty: %d", difficulty)
 
 	// Generate puzzle
-	puzzle := sudoku.CreatePuzzle(difficulty, logLevel)
+	opts := sudoku.GenerateOptions{
+		Level:      difficulty,
+		Seed:       seed,
+		Symmetry:   symmetry,
+		Minimal:    utils.ParseFlag(r.FormValue("minimal")),
+		MinClues:   minClues,
+		MaxClues:   maxClues,
+		Mask:       mask,
+		Technique:  technique,
+		Killer:     killer,
+		Jigsaw:     jigsaw,
+		NoGivens:   noGivens,
+		Regions:    regions,
+		Size:       size,
+		Samurai:    samurai,
+		AntiKnight: utils.ParseFlag(r.FormValue("anti_knight")),
+		AntiKing:   utils.ParseFlag(r.FormValue("anti_king")),
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+	defer cancel()
+	puzzle, err := sudoku.CreatePuzzle(ctx, opts, logLevel)
+	if err != nil {
+		utils.Log(utils.LogLevelWarn, "Failed to generate puzzle: %v", err)
+		http.Error(w, "No puzzle met the generation options within the time budget", http.StatusUnprocessableEntity)
+		return
+	}
 
 	// Save puzzle to disk
 	savedPuzzle, err := utils.SavePuzzle(puzzle)
@@ -101,7 +222,7 @@ func HandleGenerateSudokuPuzzle(w http.ResponseWriter, r *http.Request) {
 	// Return puzzle
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(savedPuzzle)
+	json.NewEncoder(w).Encode(savedPuzzle.ForClient())
 
 	utils.Log(utils.LogLevelInfo, "Successfully generated and saved puzzle with UUID: %s", savedPuzzle.UUID)
 }
@@ -127,22 +248,42 @@ func HandleListPuzzles(w http.ResponseWriter, r *http.Request) {
 
 	utils.Log(utils.LogLevelInfo, "Listing available puzzles")
 
-	// Get puzzles
-	puzzles, err := utils.ListPuzzles()
+	group := r.FormValue("group")
+	if group != "" && group != "signature" {
+		utils.Log(utils.LogLevelError, "Invalid group: %s", group)
+		http.Error(w, "Invalid group, expected signature", http.StatusBadRequest)
+		return
+	}
+
+	// Get puzzles, storing the rating and signature of puzzles saved before
+	// they were stored
+	puzzles, err := utils.ListPuzzles(backfillPuzzle)
 	if err != nil {
 		utils.Log(utils.LogLevelError, "Failed to list puzzles: %v", err)
 		http.Error(w, "Failed to list puzzles", http.StatusInternalServerError)
 		return
 	}
 
-	// Return puzzles
+	// Return puzzles, grouping isomorphic ones if asked to
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(puzzles)
+	if group != "" {
+		json.NewEncoder(w).Encode(utils.GroupPuzzles(puzzles, group))
+	} else {
+		json.NewEncoder(w).Encode(puzzles)
+	}
 
 	utils.Log(utils.LogLevelInfo, "Successfully listed %d puzzles", len(puzzles))
 }
 
+// backfillPuzzle rates and signs a puzzle saved before ratings and
+// signatures were stored, and reports whether it changed
+func backfillPuzzle(puzzle *models.Puzzle) bool {
+	rated := sudoku.EnsureRating(puzzle)
+	signed := sudoku.EnsureSignature(puzzle)
+	return rated || signed
+}
+
 // HandleOpenPuzzle opens a specific puzzle by UUID
 func HandleOpenPuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Parse query parameters
@@ -175,7 +316,7 @@ func HandleOpenPuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Return puzzle
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(puzzle)
+	json.NewEncoder(w).Encode(puzzle.ForClient())
 
 	utils.Log(utils.LogLevelInfo, "Successfully loaded puzzle with UUID: %s", uuid)
 }
@@ -210,17 +351,75 @@ func HandleValidatePuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 		return
 	}
 
-	// Validate puzzle solution
-	validatedPuzzle, _ := sudoku.ValidateSolution(puzzle)
+	// Validate against the stored solution rather than anything the client sent
+	attachSolution(r.Context(), &puzzle, uuid)
+	validatedPuzzle, _, err := sudoku.ValidateSolution(r.Context(), puzzle, requestLimits)
+	if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
 
 	// Return validated puzzle
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(validatedPuzzle)
+	json.NewEncoder(w).Encode(validatedPuzzle.ForClient())
 
 	utils.Log(utils.LogLevelInfo, "Successfully validated puzzle with UUID: %s", uuid)
 }
 
+// HandleAnalyze reports whether the grid in the request body has no
+// solution, one or several, listing the solutions and the cells they differ
+// in. The grid needn't be saved: every filled cell counts as a given.
+func HandleAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/analyze", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	utils.Log(utils.LogLevelInfo, "Analysing grid")
+
+	// Decode puzzle from request body
+	var puzzle models.Puzzle
+	err = json.NewDecoder(r.Body).Decode(&puzzle)
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to decode puzzle from request body: %v", err)
+		http.Error(w, "Failed to decode puzzle from request body", http.StatusBadRequest)
+		return
+	}
+
+	maxSolutions := utils.ParseSolutionCount(r.FormValue("solutions"))
+	analysis, err := sudoku.AnalyzePuzzle(r.Context(), puzzle, maxSolutions, requestLimits)
+	if err != nil {
+		writeSolverError(w, "analyze", err)
+		return
+	}
+
+	// Return analysis
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(analysis)
+
+	utils.Log(utils.LogLevelInfo, "Successfully analysed grid: %s", analysis.Status)
+}
+
 // HandleSavePuzzle saves a puzzle to the server
 func HandleSavePuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Parse query parameters
@@ -251,17 +450,36 @@ func HandleSavePuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 		return
 	}
 
-	// If new save, update creation time
+	// If new save, update creation time. The stored solution and variant
+	// rules are kept from the existing file and never taken from the client.
 	existingPuzzle, err := utils.LoadPuzzle(uuid)
 	if err != nil {
 		puzzle.CreatedAt = time.Now().Format(time.RFC3339)
+		puzzle.Solution = nil
+		if _, err := sudoku.EnsureSolution(r.Context(), &puzzle, requestLimits); errors.Is(err, sudoku.ErrInvalidPuzzle) {
+			writeSolverError(w, uuid, err)
+			return
+		} else if err != nil {
+			utils.Log(utils.LogLevelWarn, "Saving puzzle %s without a solution: %v", uuid, err)
+		}
 	} else {
 		puzzle.CreatedAt = existingPuzzle.CreatedAt
+		puzzle.Solution = existingPuzzle.Solution
+		puzzle.Source = existingPuzzle.Source
+		keepStoredRules(&puzzle, existingPuzzle)
 	}
 
-	// Save puzzle
-	savedPuzzle, err := utils.SavePuzzle(puzzle)
-	if err != nil {
+	// Save puzzle, refusing isomorphs of earlier puzzles if asked to
+	puzzle.Signature = sudoku.Signature(puzzle)
+	save := utils.SavePuzzle
+	if utils.ParseFlag(r.FormValue("unique")) {
+		save = utils.SaveUniquePuzzle
+	}
+	savedPuzzle, err := save(puzzle)
+	if errors.Is(err, utils.ErrDuplicatePuzzle) {
+		http.Error(w, fmt.Sprintf("Puzzle is a duplicate of %s", savedPuzzle.DuplicateOf), http.StatusConflict)
+		return
+	} else if err != nil {
 		utils.Log(utils.LogLevelError, "Failed to save puzzle: %v", err)
 		http.Error(w, "Failed to save puzzle", http.StatusInternalServerError)
 		return
@@ -270,11 +488,26 @@ func HandleSavePuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Return saved puzzle
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(savedPuzzle)
+	json.NewEncoder(w).Encode(savedPuzzle.ForClient())
 
 	utils.Log(utils.LogLevelInfo, "Successfully saved puzzle with UUID: %s", uuid)
 }
 
+// keepStoredRules sets the variant rules of a request puzzle from its saved
+// copy, since they can't be changed once a puzzle is saved
+func keepStoredRules(puzzle *models.Puzzle, stored models.Puzzle) {
+	puzzle.Cages = stored.Cages
+	puzzle.ExtraRegions = stored.ExtraRegions
+	puzzle.RegionMap = stored.RegionMap
+	puzzle.Size = stored.Size
+	puzzle.Symbols = stored.Symbols
+	puzzle.Grids = stored.Grids
+	puzzle.SharedCells = stored.SharedCells
+	puzzle.Constraints = stored.Constraints
+	puzzle.AntiKnight = stored.AntiKnight
+	puzzle.AntiKing = stored.AntiKing
+}
+
 // HandlePuzzleByUUID handles requests to /sudoku/{uuid}
 func HandlePuzzleByUUID(w http.ResponseWriter, r *http.Request, uuid string) {
 	utils.Log(utils.LogLevelDebug, "Handling request to /sudoku/%s: %s", uuid, r.Method)
@@ -294,6 +527,481 @@ func HandlePuzzleByUUID(w http.ResponseWriter, r *http.Request, uuid string) {
 	}
 }
 
+// HandlePuzzleAction handles requests to /sudoku/{uuid}/{action}
+func HandlePuzzleAction(w http.ResponseWriter, r *http.Request, uuid string, action string) {
+	utils.Log(utils.LogLevelDebug, "Handling request to /sudoku/%s/%s: %s", uuid, action, r.Method)
+
+	switch action {
+	case "hint":
+		HandleHint(w, r, uuid)
+	case "candidates":
+		HandleCandidates(w, r, uuid)
+	case "reveal":
+		HandleReveal(w, r, uuid)
+	case "reskin":
+		HandleReskin(w, r, uuid)
+	case "repair":
+		HandleRepair(w, r, uuid)
+	case "conflicts":
+		HandleConflicts(w, r, uuid)
+	default:
+		utils.Log(utils.LogLevelWarn, "Unknown action %s for /sudoku/%s", action, uuid)
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// HandleHint returns the next logical deduction for a puzzle. GET uses the
+// saved puzzle; POST uses the puzzle in the request body, so that the
+// player's unsaved entries are taken into account.
+func HandleHint(w http.ResponseWriter, r *http.Request, uuid string) {
+	if r.Method != "GET" && r.Method != "POST" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/%s/hint", r.Method, uuid)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	hintLevel := utils.ParseHintLevel(r.FormValue("level"))
+	utils.Log(utils.LogLevelInfo, "Finding level %d hint for puzzle with UUID: %s", hintLevel, uuid)
+
+	puzzle, ok := loadRequestPuzzle(w, r, uuid)
+	if !ok {
+		return
+	}
+
+	attachSolution(r.Context(), &puzzle, uuid)
+	hint, err := sudoku.NextHint(r.Context(), puzzle, hintLevel, requestLimits)
+	if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
+
+	// Return hint
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hint)
+
+	utils.Log(utils.LogLevelInfo, "Successfully found hint for puzzle with UUID: %s", uuid)
+}
+
+// HandleCandidates computes the candidates of every empty cell of a puzzle
+// and optionally merges them into the cells' notes. Like hints, GET uses the
+// saved puzzle and POST uses the puzzle in the request body.
+func HandleCandidates(w http.ResponseWriter, r *http.Request, uuid string) {
+	if r.Method != "GET" && r.Method != "POST" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/%s/candidates", r.Method, uuid)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	notesMode, err := sudoku.ParseNotesMode(r.FormValue("notes"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid notes mode: %v", err)
+		http.Error(w, "Invalid notes mode, expected fill or prune", http.StatusBadRequest)
+		return
+	}
+	opts := sudoku.CandidateOptions{
+		Eliminate: utils.ParseFlag(r.FormValue("eliminate")),
+		Notes:     notesMode,
+	}
+
+	utils.Log(utils.LogLevelInfo, "Computing candidates for puzzle with UUID: %s", uuid)
+
+	puzzle, ok := loadRequestPuzzle(w, r, uuid)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		attachStoredRules(&puzzle, uuid)
+	}
+
+	candidates, merged, err := sudoku.ComputeCandidates(puzzle, opts)
+	if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
+	response := map[string]interface{}{
+		"candidates": candidates,
+	}
+	if opts.Notes != sudoku.NotesKeep {
+		response["puzzle"] = merged.ForClient()
+	}
+
+	// Return candidates
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+
+	utils.Log(utils.LogLevelInfo, "Successfully computed candidates for puzzle with UUID: %s", uuid)
+}
+
+// HandleConflicts explains what is wrong with a player's board without
+// revealing the solution. Like hints, GET uses the saved puzzle and POST uses
+// the puzzle in the request body, so that unsaved entries are checked.
+func HandleConflicts(w http.ResponseWriter, r *http.Request, uuid string) {
+	if r.Method != "GET" && r.Method != "POST" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/%s/conflicts", r.Method, uuid)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	utils.Log(utils.LogLevelInfo, "Finding conflicts on puzzle with UUID: %s", uuid)
+
+	puzzle, ok := loadRequestPuzzle(w, r, uuid)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		attachStoredRules(&puzzle, uuid)
+	}
+
+	report, err := sudoku.FindConflicts(r.Context(), puzzle, requestLimits)
+	if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
+
+	// Return conflicts
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+
+	utils.Log(utils.LogLevelInfo, "Successfully found %d conflicts on puzzle with UUID: %s", len(report.Conflicts), uuid)
+}
+
+// HandleReveal reveals the solution value of the cell given by the "cell"
+// query parameter (01-81 on a 9x9 grid), or the whole solution if no cell is given
+func HandleReveal(w http.ResponseWriter, r *http.Request, uuid string) {
+	if r.Method != "GET" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/%s/reveal", r.Method, uuid)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	posKey := r.FormValue("cell")
+	utils.Log(utils.LogLevelInfo, "Revealing cell %q of puzzle with UUID: %s", posKey, uuid)
+
+	puzzle, ok := loadRequestPuzzle(w, r, uuid)
+	if !ok {
+		return
+	}
+	attachSolution(r.Context(), &puzzle, uuid)
+
+	response := map[string]interface{}{}
+	if posKey == "" {
+		response["solution"], err = sudoku.RevealSolution(r.Context(), puzzle, requestLimits)
+	} else {
+		response["cell"] = posKey
+		response["value"], err = sudoku.RevealCell(r.Context(), puzzle, posKey, requestLimits)
+	}
+	if errors.Is(err, sudoku.ErrInvalidCell) {
+		utils.Log(utils.LogLevelError, "Invalid cell to reveal: %v", err)
+		http.Error(w, "Invalid cell, expected a position key within the grid", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
+
+	// Return revealed values
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+
+	utils.Log(utils.LogLevelInfo, "Successfully revealed puzzle with UUID: %s", uuid)
+}
+
+// HandleReskin saves and returns a random isomorph of a saved puzzle, which
+// keeps its rating but looks like a new puzzle to players
+func HandleReskin(w http.ResponseWriter, r *http.Request, uuid string) {
+	if r.Method != "POST" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/%s/reskin", r.Method, uuid)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	seed, err := utils.ParseSeed(r.FormValue("seed"))
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Invalid seed: %v", err)
+		http.Error(w, "Invalid seed, expected an integer", http.StatusBadRequest)
+		return
+	}
+
+	utils.Log(utils.LogLevelInfo, "Reskinning puzzle with UUID: %s", uuid)
+
+	puzzle, err := utils.LoadPuzzle(uuid)
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to load puzzle %s: %v", uuid, err)
+		http.Error(w, "Failed to load puzzle", http.StatusNotFound)
+		return
+	}
+
+	reskin, err := sudoku.ReskinPuzzle(r.Context(), puzzle, seed, requestLimits)
+	if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
+
+	// Save the reskin as a new puzzle
+	savedPuzzle, err := utils.SavePuzzle(reskin)
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to save puzzle: %v", err)
+		http.Error(w, "Failed to save puzzle", http.StatusInternalServerError)
+		return
+	}
+
+	// Return reskinned puzzle
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(savedPuzzle.ForClient())
+
+	utils.Log(utils.LogLevelInfo, "Successfully reskinned puzzle %s as %s", uuid, savedPuzzle.UUID)
+}
+
+// HandleRepair suggests extra givens that make a puzzle with several
+// solutions unique, and with "apply" adds them and saves the puzzle. Like
+// hints, GET uses the saved puzzle and POST uses the puzzle in the request
+// body, which lets a puzzle be repaired before it is first saved.
+func HandleRepair(w http.ResponseWriter, r *http.Request, uuid string) {
+	if r.Method != "GET" && r.Method != "POST" {
+		utils.Log(utils.LogLevelWarn, "Unsupported method %s for /sudoku/%s/repair", r.Method, uuid)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	err := r.ParseForm()
+	if err != nil {
+		utils.Log(utils.LogLevelError, "Failed to parse form data: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	logLevel := utils.ParseLogLevel(r.FormValue("log_level"))
+
+	// Set log level if provided
+	if logLevel != "" {
+		oldLevel := utils.GetLogLevel()
+		utils.SetLogLevel(utils.LogLevelFromString(logLevel))
+		utils.Log(utils.LogLevelInfo, "Log level changed from %d to %d for this request", oldLevel, utils.GetLogLevel())
+	}
+
+	utils.Log(utils.LogLevelInfo, "Repairing puzzle with UUID: %s", uuid)
+
+	puzzle, ok := loadRequestPuzzle(w, r, uuid)
+	if !ok {
+		return
+	}
+	stored, err := utils.LoadPuzzle(uuid)
+	if err == nil {
+		keepStoredRules(&puzzle, stored)
+		puzzle.CreatedAt = stored.CreatedAt
+		puzzle.Source = stored.Source
+	} else {
+		puzzle.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+	puzzle.UUID = uuid
+
+	repair, repaired, err := sudoku.RepairPuzzle(r.Context(), puzzle, requestLimits)
+	if err != nil {
+		writeSolverError(w, uuid, err)
+		return
+	}
+	response := map[string]interface{}{
+		"givens":  repair.Givens,
+		"minimum": repair.Minimum,
+	}
+
+	// Save the repaired puzzle if asked to
+	if utils.ParseFlag(r.FormValue("apply")) {
+		savedPuzzle, err := utils.SavePuzzle(repaired)
+		if err != nil {
+			utils.Log(utils.LogLevelError, "Failed to save puzzle: %v", err)
+			http.Error(w, "Failed to save puzzle", http.StatusInternalServerError)
+			return
+		}
+		response["puzzle"] = savedPuzzle.ForClient()
+	}
+
+	// Return repair
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+
+	utils.Log(utils.LogLevelInfo, "Successfully repaired puzzle with UUID: %s", uuid)
+}
+
+// attachSolution sets the solution and variant rules of a request puzzle
+// from the saved copy, back-filling and saving the solution first for puzzles
+// stored before solutions were. A solution sent by the client is never
+// trusted; if none can be found the puzzle is left without one and the
+// solver reports why.
+func attachSolution(ctx context.Context, puzzle *models.Puzzle, uuid string) {
+	puzzle.Solution = nil
+
+	stored, err := utils.LoadPuzzle(uuid)
+	if err != nil {
+		utils.Log(utils.LogLevelDebug, "No saved copy of puzzle %s to take the solution from", uuid)
+		return
+	}
+	keepStoredRules(puzzle, stored)
+
+	filled, err := sudoku.EnsureSolution(ctx, &stored, requestLimits)
+	if err != nil {
+		utils.Log(utils.LogLevelWarn, "Could not back-fill solution for puzzle %s: %v", uuid, err)
+		return
+	}
+	if filled {
+		if _, err := utils.SavePuzzle(stored); err != nil {
+			utils.Log(utils.LogLevelWarn, "Failed to save back-filled solution for puzzle %s: %v", uuid, err)
+		}
+	}
+	puzzle.Solution = stored.Solution
+}
+
+// attachStoredRules sets the variant rules of a request puzzle from the saved
+// copy, if there is one, so that a client sending only the cells still gets
+// the puzzle's rules
+func attachStoredRules(puzzle *models.Puzzle, uuid string) {
+	stored, err := utils.LoadPuzzle(uuid)
+	if err != nil {
+		utils.Log(utils.LogLevelDebug, "No saved copy of puzzle %s to take the rules from", uuid)
+		return
+	}
+	keepStoredRules(puzzle, stored)
+}
+
+// loadRequestPuzzle loads a puzzle from disk for GET requests or decodes it
+// from the request body otherwise. It writes the error response itself and
+// reports false if no puzzle could be read.
+func loadRequestPuzzle(w http.ResponseWriter, r *http.Request, uuid string) (models.Puzzle, bool) {
+	if r.Method == "GET" {
+		puzzle, err := utils.LoadPuzzle(uuid)
+		if err != nil {
+			utils.Log(utils.LogLevelError, "Failed to load puzzle %s: %v", uuid, err)
+			http.Error(w, "Failed to load puzzle", http.StatusNotFound)
+			return puzzle, false
+		}
+		return puzzle, true
+	}
+
+	var puzzle models.Puzzle
+	if err := json.NewDecoder(r.Body).Decode(&puzzle); err != nil {
+		utils.Log(utils.LogLevelError, "Failed to decode puzzle from request body: %v", err)
+		http.Error(w, "Failed to decode puzzle from request body", http.StatusBadRequest)
+		return puzzle, false
+	}
+	return puzzle, true
+}
+
+// writeSolverError reports a failed solver call to the client
+func writeSolverError(w http.ResponseWriter, uuid string, err error) {
+	var budgetErr *sudoku.BudgetExceededError
+	switch {
+	case errors.As(err, &budgetErr):
+		utils.Log(utils.LogLevelWarn, "Puzzle %s exceeded the solver budget: %v", uuid, err)
+		http.Error(w, "Puzzle could not be solved within the allowed budget", http.StatusUnprocessableEntity)
+	case errors.Is(err, sudoku.ErrNoSolution):
+		utils.Log(utils.LogLevelWarn, "Puzzle %s has no solution", uuid)
+		http.Error(w, "Puzzle has no solution", http.StatusUnprocessableEntity)
+	case errors.Is(err, sudoku.ErrInvalidPuzzle):
+		utils.Log(utils.LogLevelWarn, "Puzzle %s is invalid: %v", uuid, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sudoku.ErrMultipleSolutions):
+		utils.Log(utils.LogLevelWarn, "Puzzle %s has more than one solution", uuid)
+		http.Error(w, "Puzzle has more than one solution", http.StatusUnprocessableEntity)
+	default:
+		utils.Log(utils.LogLevelError, "Solver failed for puzzle %s: %v", uuid, err)
+		http.Error(w, "Solver failed", http.StatusInternalServerError)
+	}
+}
+
 // HandleDeletePuzzle deletes a puzzle from the server
 func HandleDeletePuzzle(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Parse query parameters
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// classicPuzzle is a puzzle with a unique solution; row 1 column 3 (cell
+// 03) holds 4 in the solution, and 1, 2 and 4 are its only candidates
+const classicPuzzle = "530070000600195000098000060800060003400803001700020006060000280000419005000080079"
+
+// TestMain runs the tests in a scratch directory, since puzzles are stored
+// under the working directory
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "sudoku-api")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// makePuzzle builds a puzzle from a row-major 9x9 grid, "0" for an empty
+// cell, with its filled cells as system cells and entries as user cells
+func makePuzzle(uuid, grid string, entries map[string]int) models.Puzzle {
+	puzzle := models.Puzzle{UUID: uuid, Cells: map[string]models.Cell{}}
+	for i, ch := range grid {
+		cell := models.Cell{Value: int(ch - '0'), Notes: []int{}}
+		if cell.Value != 0 {
+			cell.Status = "s"
+		}
+		puzzle.Cells[fmt.Sprintf("%02d", i+1)] = cell
+	}
+	for posKey, value := range entries {
+		puzzle.Cells[posKey] = models.Cell{Value: value, Notes: []int{}, Status: "u"}
+	}
+	return puzzle
+}
+
+// serve sends a request to the routes, encoding body as JSON if it isn't nil
+func serve(t *testing.T, handler http.Handler, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encoding request body: %v", err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, &buf))
+	return rec
+}
+
+// decode unmarshals a JSON response into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
+
+func TestGenerateIsReproducible(t *testing.T) {
+	handler := SetupRoutes()
+	var puzzles [2]models.Puzzle
+	for i := range puzzles {
+		rec := serve(t, handler, "POST", "/sudoku?difficulty=2&seed=42", nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		decode(t, rec, &puzzles[i])
+	}
+
+	if puzzles[0].UUID == puzzles[1].UUID || puzzles[0].Seed != 42 {
+		t.Errorf("UUIDs %s and %s, seed %d", puzzles[0].UUID, puzzles[1].UUID, puzzles[0].Seed)
+	}
+	if !reflect.DeepEqual(puzzles[0].Cells, puzzles[1].Cells) {
+		t.Errorf("the same seed gave different puzzles")
+	}
+	if puzzles[0].Solution != nil {
+		t.Errorf("solution sent to the client")
+	}
+}
+
+func TestGenerateRejectsBadOptions(t *testing.T) {
+	handler := SetupRoutes()
+	for _, target := range []string{
+		"/sudoku?seed=abc",
+		"/sudoku?size=10",
+		"/sudoku?technique=fishing",
+		"/sudoku?samurai=true&killer=true",
+	} {
+		if rec := serve(t, handler, "POST", target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: status %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPuzzleEndpoints(t *testing.T) {
+	handler := SetupRoutes()
+	saved := serve(t, handler, "PUT", "/sudoku/classic-puzzle", makePuzzle("classic-puzzle", classicPuzzle, nil))
+	if saved.Code != http.StatusOK {
+		t.Fatalf("saving puzzle: status %d: %s", saved.Code, saved.Body.String())
+	}
+	var classic models.Puzzle
+	decode(t, saved, &classic)
+	if classic.Signature == "" {
+		t.Fatalf("saved puzzle has no signature")
+	}
+
+	// The steps run in order against the same store
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       interface{}
+		wantStatus int
+		check      func(t *testing.T, rec *httptest.ResponseRecorder)
+	}{
+		{
+			name: "open", method: "GET", target: "/sudoku/classic-puzzle", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var puzzle models.Puzzle
+				decode(t, rec, &puzzle)
+				if puzzle.UUID != "classic-puzzle" || puzzle.Cells["01"].Value != 5 || puzzle.Solution != nil {
+					t.Errorf("opened puzzle %s, cell 01 %d, solution %v", puzzle.UUID, puzzle.Cells["01"].Value, puzzle.Solution)
+				}
+			},
+		},
+		{
+			name: "validate", method: "POST", target: "/sudoku/classic-puzzle",
+			body:       makePuzzle("classic-puzzle", classicPuzzle, map[string]int{"03": 4, "04": 5}),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var puzzle models.Puzzle
+				decode(t, rec, &puzzle)
+				if puzzle.Cells["03"].Status != "c" || puzzle.Cells["04"].Status != "w" || puzzle.Completed {
+					t.Errorf("statuses %q and %q, completed %v", puzzle.Cells["03"].Status, puzzle.Cells["04"].Status, puzzle.Completed)
+				}
+			},
+		},
+		{
+			name: "hint", method: "GET", target: "/sudoku/classic-puzzle/hint?level=3", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var hint struct {
+					Placements []struct{ Cell string }
+				}
+				decode(t, rec, &hint)
+				if len(hint.Placements) == 0 {
+					t.Errorf("hint places nothing: %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "candidates", method: "GET", target: "/sudoku/classic-puzzle/candidates", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response struct{ Candidates map[string][]int }
+				decode(t, rec, &response)
+				if got := response.Candidates["03"]; !reflect.DeepEqual(got, []int{1, 2, 4}) {
+					t.Errorf("candidates of 03 %v, want [1 2 4]", got)
+				}
+			},
+		},
+		{
+			name: "reveal cell", method: "GET", target: "/sudoku/classic-puzzle/reveal?cell=03", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response struct{ Value int }
+				decode(t, rec, &response)
+				if response.Value != 4 {
+					t.Errorf("revealed %d, want 4", response.Value)
+				}
+			},
+		},
+		{name: "reveal bad cell", method: "GET", target: "/sudoku/classic-puzzle/reveal?cell=99", wantStatus: http.StatusBadRequest},
+		{
+			name: "no conflicts", method: "GET", target: "/sudoku/classic-puzzle/conflicts", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var report struct {
+					Solvable  bool
+					Conflicts []interface{}
+				}
+				decode(t, rec, &report)
+				if !report.Solvable || len(report.Conflicts) != 0 {
+					t.Errorf("report %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "conflicts", method: "POST", target: "/sudoku/classic-puzzle/conflicts",
+			body:       makePuzzle("classic-puzzle", classicPuzzle, map[string]int{"03": 5}),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var report struct {
+					Conflicts []struct{ Kind string }
+				}
+				decode(t, rec, &report)
+				if len(report.Conflicts) == 0 || report.Conflicts[0].Kind != "duplicate" {
+					t.Errorf("report %s, want a duplicate", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "nothing to repair", method: "GET", target: "/sudoku/classic-puzzle/repair", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response struct{ Givens []interface{} }
+				decode(t, rec, &response)
+				if len(response.Givens) != 0 {
+					t.Errorf("givens %v for a unique puzzle", response.Givens)
+				}
+			},
+		},
+		{
+			name: "repair", method: "POST", target: "/sudoku/draft-puzzle/repair?apply=true",
+			body:       makePuzzle("draft-puzzle", "000070000"+classicPuzzle[9:], nil),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response struct {
+					Givens []interface{}
+					Puzzle models.Puzzle
+				}
+				decode(t, rec, &response)
+				if len(response.Givens) == 0 || response.Puzzle.UUID != "draft-puzzle" {
+					t.Errorf("repair %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "analyze", method: "POST", target: "/sudoku/analyze?solutions=3",
+			body:       makePuzzle("", "000070000"+classicPuzzle[9:], nil),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var analysis struct {
+					Status         string
+					Solutions      [][]int
+					DifferingCells []string
+				}
+				decode(t, rec, &analysis)
+				if analysis.Status != "multiple" || len(analysis.Solutions) != 2 || len(analysis.DifferingCells) == 0 {
+					t.Errorf("analysis %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "analyze by GET", method: "GET", target: "/sudoku/analyze", wantStatus: http.StatusMethodNotAllowed},
+		{
+			name: "reskin", method: "POST", target: "/sudoku/classic-puzzle/reskin?seed=5", wantStatus: http.StatusCreated,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var reskin models.Puzzle
+				decode(t, rec, &reskin)
+				if reskin.Source != "classic-puzzle" || reskin.Signature != classic.Signature || reskin.Solution != nil {
+					t.Errorf("reskin from %q with signature %q", reskin.Source, reskin.Signature)
+				}
+			},
+		},
+		{
+			name: "save duplicate", method: "PUT", target: "/sudoku/copy-puzzle?unique=true",
+			body: makePuzzle("copy-puzzle", classicPuzzle, nil), wantStatus: http.StatusConflict,
+		},
+		{
+			name: "list by signature", method: "GET", target: "/sudoku?group=signature", wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var groups []struct {
+					Signature string
+					Puzzles   []map[string]interface{}
+				}
+				decode(t, rec, &groups)
+				for _, group := range groups {
+					if group.Signature == classic.Signature && len(group.Puzzles) == 2 {
+						return
+					}
+				}
+				t.Errorf("no group holds the puzzle and its reskin: %s", rec.Body.String())
+			},
+		},
+		{name: "list by bad group", method: "GET", target: "/sudoku?group=size", wantStatus: http.StatusBadRequest},
+		{name: "unknown action", method: "GET", target: "/sudoku/classic-puzzle/solve", wantStatus: http.StatusNotFound},
+		{name: "delete", method: "DELETE", target: "/sudoku/classic-puzzle", wantStatus: http.StatusOK},
+		{name: "open deleted", method: "GET", target: "/sudoku/classic-puzzle", wantStatus: http.StatusNotFound},
+		{name: "delete again", method: "DELETE", target: "/sudoku/classic-puzzle", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, handler, tt.method, tt.target, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s %s: status %d, want %d: %s", tt.method, tt.target, rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.check != nil {
+				tt.check(t, rec)
+			}
+		})
+	}
+}
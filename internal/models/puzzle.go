@@ -6,17 +6,66 @@ import (
 
 // Cell represents a single cell in the Sudoku grid
 type Cell struct {
-	Value  int    `json:"value"`  // 1-9, 0 means unset
-	Notes  []int  `json:"notes"`  // Array of 1-9 values
+	Value  int    `json:"value"`  // 1 up to the puzzle size, 0 means unset
+	Notes  []int  `json:"notes"`  // Array of candidate values
 	Status string `json:"status"` // s=system, u=user, w=wrong, c=correct
 }
 
+// Cage is a group of cells in a Killer Sudoku whose values add up to Sum.
+// Digits can't repeat within a cage.
+type Cage struct {
+	Cells []string `json:"cells"` // Positions (01-81)
+	Sum   int      `json:"sum"`
+}
+
+// Constraint is a variant rule drawn over some cells, such as a thermometer
+// or a Kropki dot
+type Constraint struct {
+	Type  string   `json:"type"`  // e.g. "thermo", "arrow", "kropki-white", "whisper", "even"
+	Cells []string `json:"cells"` // Positions, in order along a line
+}
+
+// Grid places one 9x9 grid of a multi-grid puzzle, such as a Samurai, on
+// the composite board
+type Grid struct {
+	Row int `json:"row"` // Board row of the grid's top left cell, from 0
+	Col int `json:"col"` // Board column of the grid's top left cell, from 0
+}
+
 // Puzzle represents a Sudoku puzzle with metadata
 type Puzzle struct {
-	UUID       string          `json:"uuid"`
-	CreatedAt  string          `json:"createdAt"`
-	Cells      map[string]Cell `json:"cells"` // Position (01-81) as key
-	Difficulty int             `json:"difficulty"`
+	UUID         string          `json:"uuid"`
+	CreatedAt    string          `json:"createdAt"`
+	Cells        map[string]Cell `json:"cells"` // Position (01-81 on a 9x9 grid) as key
+	Difficulty   int             `json:"difficulty"`
+	Size         int             `json:"size,omitempty"`         // Rows and columns, 0 for the classic 9
+	Symbols      string          `json:"symbols,omitempty"`      // Characters for the values 1..size above 9x9
+	Rating       float64         `json:"rating,omitempty"`       // Score of the hardest technique needed
+	Tier         string          `json:"tier,omitempty"`         // Named difficulty tier for the rating
+	OffBand      bool            `json:"offBand,omitempty"`      // Rating is outside the band of Difficulty, none was found in it
+	Solution     []int           `json:"solution,omitempty"`     // Row-major solution, never sent to clients
+	Completed    bool            `json:"completed,omitempty"`    // Every cell holds its solution value
+	Seed         int64           `json:"seed,omitempty"`         // Generator seed, reproduces the puzzle
+	Version      int             `json:"version,omitempty"`      // Generator version the seed applies to
+	Symmetry     string          `json:"symmetry,omitempty"`     // Symmetry of the givens, if any
+	Technique    string          `json:"technique,omitempty"`    // Technique the puzzle was generated to need
+	Cages        []Cage          `json:"cages,omitempty"`        // Killer cages, if any
+	ExtraRegions []string        `json:"extraRegions,omitempty"` // Variant regions: "diagonal", "windoku"
+	RegionMap    []int           `json:"regionMap,omitempty"`    // Row-major jigsaw region (1-9) of each cell
+	Grids        []Grid          `json:"grids,omitempty"`        // Overlapping 9x9 grids on a Size x Size board
+	SharedCells  []string        `json:"sharedCells,omitempty"`  // Positions belonging to more than one grid
+	Constraints  []Constraint    `json:"constraints,omitempty"`  // Variant rules such as thermometers and arrows
+	AntiKnight   bool            `json:"antiKnight,omitempty"`   // No equal digits a knight's move apart
+	AntiKing     bool            `json:"antiKing,omitempty"`     // No equal digits a king's move apart
+	Signature    string          `json:"signature,omitempty"`    // Canonical form of the givens, shared by isomorphic puzzles
+	DuplicateOf  string          `json:"duplicateOf,omitempty"`  // UUID of an earlier saved puzzle with the same signature
+	Source       string          `json:"source,omitempty"`       // UUID of the puzzle this one was reskinned from
+}
+
+// ForClient returns a copy of the puzzle without its stored solution
+func (p Puzzle) ForClient() Puzzle {
+	p.Solution = nil
+	return p
 }
 
 // GetTimeString returns the current time in RFC3339 format
@@ -0,0 +1,101 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// Limits on the solutions listed by an analysis
+const (
+	DefaultAnalysisSolutions = 10
+	MaxAnalysisSolutions     = 100
+)
+
+// Analysis reports whether a grid has no solution, one or several, showing
+// puzzle editors exactly where an ambiguous puzzle goes wrong
+type Analysis struct {
+	Status         string   `json:"status"`                   // "none", "unique" or "multiple"
+	Solutions      [][]int  `json:"solutions,omitempty"`      // Row-major solutions, at most the number asked for
+	DifferingCells []string `json:"differingCells,omitempty"` // Cells whose value isn't the same in every solution listed
+	Exhaustive     bool     `json:"exhaustive"`               // Every solution is listed
+}
+
+// AnalyzePuzzle lists up to maxSolutions solutions of every filled cell of a
+// puzzle, whatever its status, under the puzzle's rules. It is meant for
+// grids from elsewhere, so nothing is taken from a stored solution. A search
+// cut short by the limits still reports the solutions found if there are
+// at least two, since that settles that the grid is ambiguous.
+func AnalyzePuzzle(ctx context.Context, puzzle models.Puzzle, maxSolutions int, limits SearchLimits) (Analysis, error) {
+	if maxSolutions < 1 {
+		maxSolutions = DefaultAnalysisSolutions
+	} else if maxSolutions > MaxAnalysisSolutions {
+		maxSolutions = MaxAnalysisSolutions
+	}
+
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return Analysis{}, err
+	}
+	lay := r.lay
+	if err := checkCellValues(lay, puzzle.Cells); err != nil {
+		return Analysis{}, err
+	}
+	grid := cellsToGrid(puzzle.Cells, lay.width)
+
+	// One solution more than asked for tells whether the list is complete
+	limits.MaxSolutions = maxSolutions + 1
+	solutions, err := r.enumerate(ctx, grid, limits)
+	var budgetErr *BudgetExceededError
+	if err != nil && (!errors.As(err, &budgetErr) || len(solutions) < 2) {
+		return Analysis{}, err
+	}
+
+	uniqueness := MultipleSolutions
+	if len(solutions) < 2 {
+		uniqueness = Uniqueness(len(solutions))
+	}
+	analysis := Analysis{
+		Status:     uniqueness.String(),
+		Exhaustive: err == nil && len(solutions) <= maxSolutions,
+	}
+	if len(solutions) > maxSolutions {
+		solutions = solutions[:maxSolutions]
+	}
+	for _, solution := range solutions {
+		values := make([]int, 0, lay.width*lay.width)
+		for row := 0; row < lay.width; row++ {
+			values = append(values, solution[row][:lay.width]...)
+		}
+		analysis.Solutions = append(analysis.Solutions, values)
+	}
+	for cell := 0; cell < lay.numCells() && len(solutions) > 1; cell++ {
+		row, col := lay.rowCol(cell)
+		for _, solution := range solutions[1:] {
+			if solution[row][col] != solutions[0][row][col] {
+				analysis.DifferingCells = append(analysis.DifferingCells, lay.key(cell))
+				break
+			}
+		}
+	}
+
+	utils.Log(utils.LogLevelInfo, "Analysed grid: %s, %d solutions listed, %d cells differ",
+		analysis.Status, len(analysis.Solutions), len(analysis.DifferingCells))
+	return analysis, nil
+}
+
+// checkCellValues checks that every cell of a puzzle from elsewhere is on
+// the board and holds a digit the grid has
+func checkCellValues(lay *layout, cells map[string]models.Cell) error {
+	for posKey, cell := range cells {
+		if lay.cellOf(posKey) < 0 {
+			return invalidPuzzle("invalid cell %q", posKey)
+		}
+		if cell.Value < 0 || cell.Value > lay.size {
+			return invalidPuzzle("cell %s holds %d, expected 0 to %d", posKey, cell.Value, lay.size)
+		}
+	}
+	return nil
+}
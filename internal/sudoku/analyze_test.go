@@ -0,0 +1,61 @@
+package sudoku
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzePuzzle(t *testing.T) {
+	tests := []struct {
+		name           string
+		grid           string
+		maxSolutions   int
+		wantStatus     string
+		wantSolutions  int
+		wantExhaustive bool
+	}{
+		{"unique", classicPuzzle, 5, "unique", 1, true},
+		{"ambiguous", "000070000" + classicPuzzle[9:], 5, "multiple", 2, true},
+		{"wide open", strings.Repeat("0", 18) + classicPuzzle[18:], 5, "multiple", 5, false},
+		{"no solution", "55" + classicPuzzle[2:], 5, "none", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puzzle := givensPuzzle(parseGrid(t, tt.grid, 9), classicLayout)
+			analysis, err := AnalyzePuzzle(context.Background(), puzzle, tt.maxSolutions, SearchLimits{})
+			if err != nil {
+				t.Fatalf("AnalyzePuzzle: %v", err)
+			}
+			if analysis.Status != tt.wantStatus || len(analysis.Solutions) != tt.wantSolutions || analysis.Exhaustive != tt.wantExhaustive {
+				t.Errorf("status %q with %d solutions, exhaustive %v; want %q with %d, %v", analysis.Status,
+					len(analysis.Solutions), analysis.Exhaustive, tt.wantStatus, tt.wantSolutions, tt.wantExhaustive)
+			}
+
+			// Cells differ exactly where the listed solutions disagree
+			differ := map[string]bool{}
+			for _, posKey := range analysis.DifferingCells {
+				differ[posKey] = true
+			}
+			for cell := 0; cell < 81 && len(analysis.Solutions) > 1; cell++ {
+				same := true
+				for _, solution := range analysis.Solutions[1:] {
+					same = same && solution[cell] == analysis.Solutions[0][cell]
+				}
+				if same == differ[cellKey(cell)] {
+					t.Errorf("cell %s listed as differing: %v", cellKey(cell), differ[cellKey(cell)])
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzePuzzleCountsUserEntries(t *testing.T) {
+	// An entry counts as much as a given, even a wrong one
+	puzzle := givensPuzzle(parseGrid(t, classicPuzzle, 9), classicLayout)
+	addEntries(puzzle, map[string]int{"03": 1})
+	analysis, err := AnalyzePuzzle(context.Background(), puzzle, 0, SearchLimits{})
+	if err != nil || analysis.Status != "none" {
+		t.Errorf("status %q (%v), want none", analysis.Status, err)
+	}
+}
@@ -0,0 +1,124 @@
+package sudoku
+
+import (
+	"fmt"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// Ways of merging computed candidates into a puzzle's notes
+const (
+	NotesKeep  = ""      // Leave notes untouched
+	NotesFill  = "fill"  // Replace the notes of every empty cell with its candidates
+	NotesPrune = "prune" // Remove notes that are no longer candidates
+)
+
+// CandidateOptions controls how candidates are computed and merged
+type CandidateOptions struct {
+	Eliminate bool   // Apply basic eliminations, not just the row/column/box rule
+	Notes     string // One of NotesKeep, NotesFill or NotesPrune
+}
+
+// basicEliminations are the techniques applied when CandidateOptions.Eliminate is set
+var basicEliminations = []func(st *logicState) *deduction{
+	func(st *logicState) *deduction { return st.lockedCandidates(true) },
+	func(st *logicState) *deduction { return st.lockedCandidates(false) },
+	func(st *logicState) *deduction { return st.nakedSubset(2) },
+	func(st *logicState) *deduction { return st.hiddenSubset(2) },
+	func(st *logicState) *deduction { return st.constraintRule(TechniqueCageSum) },
+	func(st *logicState) *deduction { return st.constraintRule(TechniqueConstraint) },
+}
+
+// ParseNotesMode validates a notes merge mode
+func ParseNotesMode(mode string) (string, error) {
+	switch mode {
+	case NotesKeep, NotesFill, NotesPrune:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown notes mode %q", mode)
+	}
+}
+
+// ComputeCandidates works out the candidates of every empty cell from the
+// system cells and the user's entries, keyed by position. It also returns
+// the puzzle with the candidates merged into its notes as requested.
+func ComputeCandidates(puzzle models.Puzzle, opts CandidateOptions) (map[string][]int, models.Puzzle, error) {
+	utils.Log(utils.LogLevelDebug, "Computing candidates (eliminate=%v, notes=%q)", opts.Eliminate, opts.Notes)
+
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return nil, puzzle, err
+	}
+
+	lay := r.lay
+	grid := cellsToGrid(puzzle.Cells, lay.width)
+	var candidates CandidateGrid
+	if opts.Eliminate {
+		candidates = eliminatedCandidates(r, grid)
+	} else {
+		candidates = r.solver().Candidates(grid)
+	}
+
+	result := make(map[string][]int)
+	for cell := 0; cell < lay.numCells(); cell++ {
+		row, col := lay.rowCol(cell)
+		if grid[row][col] == 0 {
+			result[lay.key(cell)] = candidates[row][col]
+		}
+	}
+
+	if opts.Notes == NotesKeep {
+		return result, puzzle, nil
+	}
+
+	merged := puzzle
+	merged.Cells = make(map[string]models.Cell, len(puzzle.Cells))
+	for posKey, cell := range puzzle.Cells {
+		if digits, ok := result[posKey]; ok {
+			switch opts.Notes {
+			case NotesFill:
+				cell.Notes = append([]int{}, digits...)
+			case NotesPrune:
+				cell.Notes = intersectDigits(cell.Notes, digits)
+			}
+		}
+		merged.Cells[posKey] = cell
+	}
+	return result, merged, nil
+}
+
+// eliminatedCandidates applies basicEliminations until none makes progress
+func eliminatedCandidates(r *rules, grid PuzzleGrid) CandidateGrid {
+	st := newLogicState(r, grid)
+	for progress := true; progress; {
+		progress = false
+		for _, eliminate := range basicEliminations {
+			if d := eliminate(st); d != nil {
+				st.apply(d)
+				progress = true
+				break
+			}
+		}
+	}
+
+	var candidates CandidateGrid
+	for cell, v := range st.values {
+		if v == 0 {
+			row, col := r.lay.rowCol(cell)
+			candidates[row][col] = maskDigits(st.cands[cell])
+		}
+	}
+	return candidates
+}
+
+// intersectDigits keeps the digits of notes that are also in allowed
+func intersectDigits(notes, allowed []int) []int {
+	kept := []int{}
+	for _, note := range notes {
+		if intsContain(allowed, note) {
+			kept = append(kept, note)
+		}
+	}
+	return kept
+}
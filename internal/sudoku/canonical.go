@@ -0,0 +1,293 @@
+package sudoku
+
+import (
+	"strings"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// Isomorphic puzzles are the same puzzle to a player: relabelling the digits,
+// reordering the bands (rows of boxes) and stacks (columns of boxes),
+// reordering the rows within a band or the columns within a stack, and
+// transposing all keep a grid valid and its solution unique. The canonical
+// form picks one grid to stand for all of them, so isomorphs share it.
+
+// maxCanonicalSize is the largest grid the canonical form is worked out
+// for. Above it there are far too many transformations to try them all.
+const maxCanonicalSize = 9
+
+// CanonicalForm returns the canonical form of a size x size grid: the
+// lexicographically smallest row-major string, with "." for an empty cell,
+// of any transformation of it. Digits are relabelled in order of first
+// appearance, so the first digit is always "1". It returns "" for sizes it
+// can't work out.
+func CanonicalForm(grid PuzzleGrid, size int) string {
+	shape, ok := boxShapes[size]
+	if !ok || size > maxCanonicalSize {
+		return ""
+	}
+
+	search := canonicalSearch{
+		grid:       grid,
+		size:       size,
+		bandHeight: shape[0],
+		best:       make([]int, size*size),
+		order:      make([]int, 0, size),
+		rowUsed:    make([]bool, size),
+		relabels:   make([][]int, size+1),
+	}
+	for i := range search.best {
+		search.best[i] = size + 1
+	}
+	for i := range search.relabels {
+		search.relabels[i] = make([]int, size+1)
+	}
+	search.relabels[0][0] = 1
+
+	transposes := []bool{false}
+	if shape[0] == shape[1] {
+		transposes = append(transposes, true)
+	}
+	for _, transpose := range transposes {
+		search.transpose = transpose
+		for _, cols := range lineOrders(shape[1], size/shape[1]) {
+			if !search.inEmptyOrder(cols, shape[1]) {
+				continue
+			}
+			search.cols = cols
+			search.place(0)
+		}
+	}
+
+	symbols := Symbols(size)
+	var form strings.Builder
+	for _, v := range search.best {
+		if v == 0 {
+			form.WriteByte('.')
+		} else {
+			form.WriteByte(symbols[v-1])
+		}
+	}
+	return form.String()
+}
+
+// canonicalSearch finds the canonical form for each column order by
+// placing rows one at a time. A row that comes out larger than the same row
+// of the best form so far ends that branch, so most row orders are never
+// looked at. Empty lines tie with each other everywhere, so only one order
+// of the empty lines in a band or stack, and of wholly empty bands or
+// stacks, is tried; sparse grids would otherwise try every order of them.
+type canonicalSearch struct {
+	grid       PuzzleGrid
+	size       int
+	bandHeight int
+	transpose  bool
+	cols       []int   // column order being tried
+	best       []int   // smallest form so far, size+1 where not yet known
+	order      []int   // rows placed so far
+	rowUsed    []bool  // whether each row has been placed
+	relabels   [][]int // labels given after each depth; entry 0 holds the next label
+}
+
+// place tries each row allowed at depth. A band is finished before the next
+// starts, so at the start of a band any unplaced row may follow; otherwise
+// only the rest of the band already started.
+func (s *canonicalSearch) place(depth int) {
+	if depth == s.size {
+		return
+	}
+	first, last := 0, s.size
+	if depth%s.bandHeight != 0 {
+		first = s.order[depth-depth%s.bandHeight] / s.bandHeight * s.bandHeight
+		last = first + s.bandHeight
+	}
+
+	triedEmpty := make([]bool, s.size/s.bandHeight+1)
+	for row := first; row < last; row++ {
+		if s.rowUsed[row] {
+			continue
+		}
+		if s.lineEmpty(row) {
+			// Empty rows of one band, or rows of wholly empty bands, give
+			// the same forms, so only the first is tried
+			band := row / s.bandHeight
+			if s.bandEmpty(band) {
+				band = len(triedEmpty) - 1
+			}
+			if triedEmpty[band] {
+				continue
+			}
+			triedEmpty[band] = true
+		}
+		if !s.placeRow(depth, row) {
+			continue
+		}
+		s.rowUsed[row] = true
+		s.order = append(s.order, row)
+		s.place(depth + 1)
+		s.order = s.order[:depth]
+		s.rowUsed[row] = false
+	}
+}
+
+// placeRow relabels row as the row at depth and compares it with the best
+// form. It returns false if the row is larger, and makes it the best if it
+// is smaller.
+func (s *canonicalSearch) placeRow(depth, row int) bool {
+	relabel := s.relabels[depth+1]
+	copy(relabel, s.relabels[depth])
+
+	best := s.best[depth*s.size : (depth+1)*s.size]
+	smaller := false
+	for i, col := range s.cols {
+		v := s.grid[row][col]
+		if s.transpose {
+			v = s.grid[col][row]
+		}
+		if v != 0 {
+			if relabel[v] == 0 {
+				relabel[v] = relabel[0]
+				relabel[0]++
+			}
+			v = relabel[v]
+		}
+
+		if !smaller {
+			if v > best[i] {
+				return false
+			}
+			smaller = v < best[i]
+		}
+		if smaller {
+			best[i] = v
+		}
+	}
+	if smaller {
+		for i := (depth + 1) * s.size; i < len(s.best); i++ {
+			s.best[i] = s.size + 1
+		}
+	}
+	return true
+}
+
+// lineEmpty reports whether a row of the grid, as seen after any transpose,
+// has no givens. With transpose false this is a row, otherwise a column.
+func (s *canonicalSearch) lineEmpty(line int) bool {
+	for i := 0; i < s.size; i++ {
+		v := s.grid[line][i]
+		if s.transpose {
+			v = s.grid[i][line]
+		}
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bandEmpty reports whether every row of a band is empty
+func (s *canonicalSearch) bandEmpty(band int) bool {
+	for row := band * s.bandHeight; row < (band+1)*s.bandHeight; row++ {
+		if !s.lineEmpty(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// inEmptyOrder reports whether a column order keeps the empty columns of
+// each stack, and the wholly empty stacks, in their original order. Other
+// orders of them give the same forms.
+func (s *canonicalSearch) inEmptyOrder(cols []int, stackWidth int) bool {
+	s.transpose = !s.transpose
+	defer func() { s.transpose = !s.transpose }()
+
+	lastEmptyStack := -1
+	for start := 0; start < len(cols); start += stackWidth {
+		stack := cols[start] / stackWidth
+		empty, lastEmpty := true, -1
+		for _, col := range cols[start : start+stackWidth] {
+			if !s.lineEmpty(col) {
+				empty = false
+				continue
+			}
+			if col < lastEmpty {
+				return false
+			}
+			lastEmpty = col
+		}
+		if empty {
+			if stack < lastEmptyStack {
+				return false
+			}
+			lastEmptyStack = stack
+		}
+	}
+	return true
+}
+
+// lineOrders returns every order of size lines, made of groups of groupSize
+// lines, that keeps each group together: the groups in any order, and the
+// lines within each group in any order
+func lineOrders(groupSize, groups int) [][]int {
+	var orders [][]int
+	withinOrders := permutations(groupSize)
+	for _, groupOrder := range permutations(groups) {
+		// Pick an order within each group in turn
+		var pick func(g int, order []int)
+		pick = func(g int, order []int) {
+			if g == groups {
+				orders = append(orders, append([]int{}, order...))
+				return
+			}
+			for _, within := range withinOrders {
+				for _, line := range within {
+					order = append(order, groupOrder[g]*groupSize+line)
+				}
+				pick(g+1, order)
+				order = order[:len(order)-groupSize]
+			}
+		}
+		pick(0, make([]int, 0, groupSize*groups))
+	}
+	return orders
+}
+
+// permutations returns every ordering of 0..n-1
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var perms [][]int
+	for _, shorter := range permutations(n - 1) {
+		for pos := 0; pos <= len(shorter); pos++ {
+			perm := make([]int, 0, n)
+			perm = append(perm, shorter[:pos]...)
+			perm = append(perm, n-1)
+			perm = append(perm, shorter[pos:]...)
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+// Signature returns the canonical form of a puzzle's givens, which isomorphic
+// puzzles share. Variant rules aren't kept by every transformation, so
+// variant puzzles and sizes above 9x9 have no signature and "" is returned.
+func Signature(puzzle models.Puzzle) string {
+	r, err := rulesFor(puzzle)
+	if err != nil || r != sizeRules[r.lay.size] {
+		return ""
+	}
+	return CanonicalForm(givensGrid(puzzle), r.lay.size)
+}
+
+// EnsureSignature fills in the signature of a puzzle saved before signatures
+// were stored, and reports whether the puzzle changed
+func EnsureSignature(puzzle *models.Puzzle) bool {
+	if puzzle.Signature != "" {
+		return false
+	}
+	puzzle.Signature = Signature(*puzzle)
+	return puzzle.Signature != ""
+}
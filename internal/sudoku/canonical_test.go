@@ -0,0 +1,103 @@
+package sudoku
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// randomIsomorph applies a random relabelling, band, stack, row and column
+// order and, for square boxes, maybe a transpose to a size x size grid
+func randomIsomorph(grid PuzzleGrid, size int, rng *rand.Rand) PuzzleGrid {
+	shape := boxShapes[size]
+	rowOrders := lineOrders(shape[0], size/shape[0])
+	colOrders := lineOrders(shape[1], size/shape[1])
+	rows := rowOrders[rng.Intn(len(rowOrders))]
+	cols := colOrders[rng.Intn(len(colOrders))]
+	relabel := append([]int{0}, rng.Perm(size)...)
+	for i := 1; i <= size; i++ {
+		relabel[i]++
+	}
+	transpose := shape[0] == shape[1] && rng.Intn(2) == 1
+
+	var out PuzzleGrid
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			v := grid[rows[row]][cols[col]]
+			if transpose {
+				out[col][row] = relabel[v]
+			} else {
+				out[row][col] = relabel[v]
+			}
+		}
+	}
+	return out
+}
+
+func TestCanonicalFormIsomorphs(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		grid string
+	}{
+		{"puzzle", 9, classicPuzzle},
+		{"solution", 9, classicSolution},
+		{"empty", 9, strings.Repeat("0", 81)},
+		{"one given", 9, "5" + strings.Repeat("0", 80)},
+		{"two givens", 9, "5" + strings.Repeat("0", 39) + "3" + strings.Repeat("0", 40)},
+		{"6x6", 6, "120000000340005000000006000000000021"},
+		{"4x4", 4, "1000002000000300"},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grid := parseGrid(t, tt.grid, tt.size)
+			want := CanonicalForm(grid, tt.size)
+			if len(want) != tt.size*tt.size {
+				t.Fatalf("canonical form %q has %d cells", want, len(want))
+			}
+			if first := strings.TrimLeft(want, "."); first != "" && first[0] != '1' {
+				t.Errorf("canonical form %q doesn't start with 1", want)
+			}
+			for i := 0; i < 20; i++ {
+				isomorph := randomIsomorph(grid, tt.size, rng)
+				if got := CanonicalForm(isomorph, tt.size); got != want {
+					t.Fatalf("isomorph\n%s has form %s, want %s", PrintGrid(isomorph, tt.size), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalFormTellsPuzzlesApart(t *testing.T) {
+	puzzle := parseGrid(t, classicPuzzle, 9)
+	moved := puzzle
+	moved[0][0], moved[0][2] = 0, 5
+	if CanonicalForm(puzzle, 9) == CanonicalForm(moved, 9) {
+		t.Errorf("moving a given kept the canonical form")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	grid := parseGrid(t, classicPuzzle, 9)
+	plain := givensPuzzle(grid, classicLayout)
+	diagonal := plain
+	diagonal.ExtraRegions = []string{RegionDiagonal}
+
+	tests := []struct {
+		name   string
+		puzzle models.Puzzle
+		want   string
+	}{
+		{"classic", plain, CanonicalForm(grid, 9)},
+		{"variant", diagonal, ""},
+		{"16x16", models.Puzzle{Size: 16, Cells: map[string]models.Cell{}}, ""},
+	}
+	for _, tt := range tests {
+		if got := Signature(tt.puzzle); got != tt.want {
+			t.Errorf("%s: signature %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package sudoku
+
+// Chess rules forbid equal digits a chess piece's move apart anywhere on the
+// board. They add peers rather than units, since the cells a move away from
+// each other don't have to hold every digit.
+
+// knightMoves are the row and column offsets of a knight's move
+var knightMoves = [][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
+
+// kingMoves are the diagonal king moves; cells an orthogonal king's move
+// apart share a row or column already
+var kingMoves = [][2]int{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}}
+
+// chessMoves returns the moves whose cells the layout's chess rules make peers
+func (lay *layout) chessMoves() [][2]int {
+	var moves [][2]int
+	if lay.antiKnight {
+		moves = append(moves, knightMoves...)
+	}
+	if lay.antiKing {
+		moves = append(moves, kingMoves...)
+	}
+	return moves
+}
+
+// withChessRules returns a copy of the layout with the anti-knight and
+// anti-king rules set as given
+func (lay *layout) withChessRules(antiKnight, antiKing bool) *layout {
+	if lay.antiKnight == antiKnight && lay.antiKing == antiKing {
+		return lay
+	}
+	extended := *lay
+	extended.antiKnight, extended.antiKing = antiKnight, antiKing
+	extended.index()
+	return &extended
+}
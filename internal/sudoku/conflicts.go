@@ -0,0 +1,207 @@
+package sudoku
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// Kinds of conflict on a player's board
+const (
+	ConflictDuplicate    = "duplicate"     // A digit appears twice in a unit or a chess move apart
+	ConflictConstraint   = "constraint"    // Filled cells break a cage or variant constraint
+	ConflictNoCandidates = "no-candidates" // An empty cell has no digit left
+	ConflictUnsolvable   = "unsolvable"    // Entries that together leave no solution
+)
+
+// Conflict is a group of cells that can't all be right, for the client to
+// highlight
+type Conflict struct {
+	Kind    string   `json:"kind"`
+	Cells   []string `json:"cells"`           // Position keys of the cells involved
+	Value   int      `json:"value,omitempty"` // The repeated digit of a duplicate
+	Unit    string   `json:"unit,omitempty"`  // Where a duplicate clashes, e.g. "row 3"
+	Message string   `json:"message"`
+}
+
+// ConflictReport lists what is wrong with a player's board
+type ConflictReport struct {
+	Solvable  bool       `json:"solvable"` // The board can still be completed
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// FindConflicts explains why a player's board can't be completed without
+// giving the solution away. Direct conflicts come first: digits repeated in
+// a unit or a chess move apart, broken constraints and empty cells with no
+// candidates left. If there are none but the board still has no solution,
+// the user entries are narrowed down to a minimal group that has none: each
+// is dropped in turn unless dropping it makes the board solvable. Nothing is
+// compared with the stored solution. ErrNoSolution is returned if the system
+// cells alone have no solution.
+func FindConflicts(ctx context.Context, puzzle models.Puzzle, limits SearchLimits) (ConflictReport, error) {
+	report := ConflictReport{Conflicts: []Conflict{}}
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return report, err
+	}
+	lay := r.lay
+	if err := checkCellValues(lay, puzzle.Cells); err != nil {
+		return report, err
+	}
+	if limits.Timeout > 0 {
+		// The limits apply to the whole search rather than to each solve
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+		limits.Timeout = 0
+	}
+
+	grid := cellsToGrid(puzzle.Cells, lay.width)
+	report.Conflicts = directConflicts(r, grid)
+	if len(report.Conflicts) > 0 {
+		utils.Log(utils.LogLevelInfo, "Found %d direct conflicts on puzzle %s", len(report.Conflicts), puzzle.UUID)
+		return report, nil
+	}
+
+	report.Solvable, err = r.solvable(ctx, grid, limits)
+	if err != nil || report.Solvable {
+		return report, err
+	}
+	givens := givensGrid(puzzle)
+	if solvable, err := r.solvable(ctx, givens, limits); err != nil {
+		return report, err
+	} else if !solvable {
+		return report, ErrNoSolution
+	}
+
+	// Drop each user entry in turn, keeping it if the rest become solvable
+	var entries []int
+	for cell := 0; cell < lay.numCells(); cell++ {
+		row, col := lay.rowCol(cell)
+		if grid[row][col] != 0 && givens[row][col] == 0 {
+			entries = append(entries, cell)
+		}
+	}
+	remaining := grid
+	var group []string
+	for _, cell := range entries {
+		row, col := lay.rowCol(cell)
+		without := remaining
+		without[row][col] = 0
+		solvable, err := r.solvable(ctx, without, limits)
+		if err != nil {
+			return report, err
+		}
+		if solvable {
+			group = append(group, lay.key(cell))
+		} else {
+			remaining = without
+		}
+	}
+	report.Conflicts = append(report.Conflicts, Conflict{
+		Kind:    ConflictUnsolvable,
+		Cells:   group,
+		Message: "These entries can't all be right: together they leave no solution",
+	})
+
+	utils.Log(utils.LogLevelInfo, "Narrowed the entries of puzzle %s down to %d that leave no solution", puzzle.UUID, len(group))
+	return report, nil
+}
+
+// directConflicts lists the conflicts visible without searching
+func directConflicts(r *rules, grid PuzzleGrid) []Conflict {
+	lay := r.lay
+	b := newBoardFor(r, grid)
+	conflicts := []Conflict{}
+
+	// Digits repeated within a unit
+	for u, unit := range lay.units {
+		byValue := make(map[int][]int)
+		for _, cell := range unit {
+			if v := b.cells[cell]; v != 0 {
+				byValue[v] = append(byValue[v], cell)
+			}
+		}
+		for v := 1; v <= lay.size; v++ {
+			if cells := byValue[v]; len(cells) > 1 {
+				conflicts = append(conflicts, duplicateConflict(lay, cells, v, lay.unitNames[u]))
+			}
+		}
+	}
+
+	// Equal digits a chess move apart
+	for cell, v := range b.cells {
+		if v == 0 {
+			continue
+		}
+		row, col := lay.rowCol(cell)
+		for _, move := range lay.chessMoves() {
+			other := lay.cellAt(row+move[0], col+move[1])
+			if other > cell && b.cells[other] == v {
+				piece := "king"
+				if absInt(move[0]) != absInt(move[1]) {
+					piece = "knight"
+				}
+				conflict := duplicateConflict(lay, []int{cell, other}, v, "")
+				conflict.Message = fmt.Sprintf("The %ds at %s and %s are a %s's move apart",
+					v, lay.cellName(cell), lay.cellName(other), piece)
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	// Cages and variant constraints broken by filled cells
+	for _, i := range r.broken(grid) {
+		c := r.constraints[i]
+		conflicts = append(conflicts, Conflict{
+			Kind:    ConflictConstraint,
+			Cells:   cellKeys(lay, c.Cells()),
+			Message: fmt.Sprintf("These digits break %s", c.Describe(lay.cellName)),
+		})
+	}
+
+	// Empty cells every digit is ruled out of
+	for cell, v := range b.cells {
+		if v == 0 && b.candidates(cell) == 0 {
+			conflicts = append(conflicts, Conflict{
+				Kind:    ConflictNoCandidates,
+				Cells:   []string{lay.key(cell)},
+				Message: fmt.Sprintf("No digit can go in %s", lay.cellName(cell)),
+			})
+		}
+	}
+	return conflicts
+}
+
+// duplicateConflict describes the same digit in several cells of a unit
+func duplicateConflict(lay *layout, cells []int, value int, unit string) Conflict {
+	names := make([]string, len(cells))
+	for i, cell := range cells {
+		names[i] = lay.cellName(cell)
+	}
+	list := strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+	return Conflict{
+		Kind:    ConflictDuplicate,
+		Cells:   cellKeys(lay, cells),
+		Value:   value,
+		Unit:    unit,
+		Message: fmt.Sprintf("The %ds at %s clash in %s", value, list, unit),
+	}
+}
+
+// cellKeys returns the position keys of cells
+func cellKeys(lay *layout, cells []int) []string {
+	keys := make([]string, len(cells))
+	for i, cell := range cells {
+		keys[i] = lay.key(cell)
+	}
+	return keys
+}
+
+// solvable reports whether grid has a solution under the rules
+func (r *rules) solvable(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (bool, error) {
+	return r.solver().Solve(ctx, &grid, limits)
+}
@@ -0,0 +1,49 @@
+package sudoku
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	// Row 1 column 3 holds 4 in the solution, and 1, 2 and 4 are its only
+	// candidates from the givens
+	tests := []struct {
+		name         string
+		entries      map[string]int
+		wantSolvable bool
+		wantKind     string
+		wantCells    []string
+	}{
+		{"correct entries", map[string]int{"03": 4, "08": 1}, true, "", nil},
+		{"duplicate", map[string]int{"03": 5}, false, ConflictDuplicate, []string{"01", "03"}},
+		{"no candidates", map[string]int{"08": 1, "09": 2, "57": 4}, false, ConflictNoCandidates, []string{"03"}},
+		{"unsolvable", map[string]int{"03": 1, "09": 2}, false, ConflictUnsolvable, []string{"03"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puzzle := givensPuzzle(parseGrid(t, classicPuzzle, 9), classicLayout)
+			addEntries(puzzle, tt.entries)
+			report, err := FindConflicts(context.Background(), puzzle, SearchLimits{})
+			if err != nil {
+				t.Fatalf("FindConflicts: %v", err)
+			}
+			if report.Solvable != tt.wantSolvable {
+				t.Errorf("solvable %v, want %v", report.Solvable, tt.wantSolvable)
+			}
+			if tt.wantKind == "" {
+				if len(report.Conflicts) > 0 {
+					t.Errorf("conflicts %+v, want none", report.Conflicts)
+				}
+				return
+			}
+			if len(report.Conflicts) == 0 {
+				t.Fatalf("no conflicts, want %s", tt.wantKind)
+			}
+			if got := report.Conflicts[0]; got.Kind != tt.wantKind || !reflect.DeepEqual(got.Cells, tt.wantCells) {
+				t.Errorf("conflict %+v, want %s at %v", got, tt.wantKind, tt.wantCells)
+			}
+		})
+	}
+}
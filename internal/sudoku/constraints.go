@@ -0,0 +1,330 @@
+package sudoku
+
+import (
+	"fmt"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// Variant constraints drawn over the grid on top of the usual rules. Each
+// type is converted into one or more constraints by a builder, so the native
+// solver, the logical solver and validation all handle them without knowing
+// what they are. Lines (thermometers, arrows and whispers) run through cells
+// that touch, diagonally or not; dots and XV marks sit between two cells
+// sharing an edge.
+const (
+	ConstraintThermo      = "thermo"       // Digits strictly increase from the bulb, the first cell
+	ConstraintArrow       = "arrow"        // The circle, the first cell, is the sum of the rest
+	ConstraintKropkiWhite = "kropki-white" // The two cells hold consecutive digits
+	ConstraintKropkiBlack = "kropki-black" // One of the two cells is double the other
+	ConstraintX           = "x"            // The two cells add up to 10
+	ConstraintV           = "v"            // The two cells add up to 5
+	ConstraintWhisper     = "whisper"      // Neighbours on the line differ by at least 5 on a 9x9 grid
+	ConstraintEven        = "even"         // Every cell holds an even digit
+	ConstraintOdd         = "odd"          // Every cell holds an odd digit
+)
+
+// constraintBuilder converts the cells of a stored constraint into
+// constraints, or explains why they don't make sense
+type constraintBuilder func(lay *layout, cells []int) ([]Constraint, error)
+
+// constraintBuilders maps each constraint type to its builder. A new variant
+// rule only needs a Constraint implementation and an entry here.
+var constraintBuilders = map[string]constraintBuilder{
+	ConstraintThermo: func(lay *layout, cells []int) ([]Constraint, error) {
+		if err := checkLine(lay, cells, 2, lay.size); err != nil {
+			return nil, err
+		}
+		return []Constraint{&thermometer{line: cells, size: lay.size}}, nil
+	},
+	ConstraintArrow: func(lay *layout, cells []int) ([]Constraint, error) {
+		if err := checkLine(lay, cells, 2, len(cells)); err != nil {
+			return nil, err
+		}
+		return []Constraint{&arrow{circle: cells[0], shaft: cells[1:], size: lay.size}}, nil
+	},
+	ConstraintKropkiWhite: pairBuilder("white dot", func(a, b int) bool { return a-b == 1 || b-a == 1 }),
+	ConstraintKropkiBlack: pairBuilder("black dot", func(a, b int) bool { return a == 2*b || b == 2*a }),
+	ConstraintX:           pairBuilder("X", func(a, b int) bool { return a+b == 10 }),
+	ConstraintV:           pairBuilder("V", func(a, b int) bool { return a+b == 5 }),
+	ConstraintWhisper: func(lay *layout, cells []int) ([]Constraint, error) {
+		if err := checkLine(lay, cells, 2, len(cells)); err != nil {
+			return nil, err
+		}
+		gap := (lay.size + 1) / 2
+		whisper := func(a, b int) bool { return a-b >= gap || b-a >= gap }
+		pairs := make([]Constraint, 0, len(cells)-1)
+		for i := 1; i < len(cells); i++ {
+			pairs = append(pairs, newPairRule(lay, "whisper", whisper, cells[i-1], cells[i]))
+		}
+		return pairs, nil
+	},
+	ConstraintEven: parityBuilder("even", 0),
+	ConstraintOdd:  parityBuilder("odd", 1),
+}
+
+// constraintsFromModel checks and converts the stored variant constraints of
+// a puzzle
+func constraintsFromModel(lay *layout, modelConstraints []models.Constraint) ([]Constraint, error) {
+	var constraints []Constraint
+	for i, mc := range modelConstraints {
+		build, ok := constraintBuilders[mc.Type]
+		if !ok {
+			return nil, invalidPuzzle("constraint %d has unknown type %q", i+1, mc.Type)
+		}
+		if len(mc.Cells) == 0 {
+			return nil, invalidPuzzle("constraint %d has no cells", i+1)
+		}
+
+		cells := make([]int, 0, len(mc.Cells))
+		for _, posKey := range mc.Cells {
+			cell := lay.cellOf(posKey)
+			if cell < 0 {
+				return nil, invalidPuzzle("constraint %d has invalid cell %q", i+1, posKey)
+			}
+			if intsContain(cells, cell) {
+				return nil, invalidPuzzle("constraint %d lists %s twice", i+1, lay.cellName(cell))
+			}
+			cells = append(cells, cell)
+		}
+
+		built, err := build(lay, cells)
+		if err != nil {
+			return nil, fmt.Errorf("constraint %d (%s): %w", i+1, mc.Type, err)
+		}
+		constraints = append(constraints, built...)
+	}
+	return constraints, nil
+}
+
+// checkLine checks that a line has between fewest and most cells, each
+// touching the one before it
+func checkLine(lay *layout, cells []int, fewest, most int) error {
+	if len(cells) < fewest || len(cells) > most {
+		return invalidPuzzle("line has %d cells, expected %d to %d", len(cells), fewest, most)
+	}
+	for i := 1; i < len(cells); i++ {
+		if rows, cols := cellDistance(lay, cells[i-1], cells[i]); rows > 1 || cols > 1 {
+			return invalidPuzzle("%s and %s don't touch", lay.cellName(cells[i-1]), lay.cellName(cells[i]))
+		}
+	}
+	return nil
+}
+
+// cellDistance returns how many rows and columns apart two cells are
+func cellDistance(lay *layout, a, b int) (int, int) {
+	rowA, colA := lay.rowCol(a)
+	rowB, colB := lay.rowCol(b)
+	return absInt(rowA - rowB), absInt(colA - colB)
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// digitRange returns the mask of the digits lo..hi that exist on a grid
+// with digits up to size
+func digitRange(lo, hi, size int) uint32 {
+	if lo < 1 {
+		lo = 1
+	}
+	if hi > size {
+		hi = size
+	}
+	if lo > hi {
+		return 0
+	}
+	return (uint32(1)<<uint(hi+1) - 1) &^ (uint32(1)<<uint(lo) - 1)
+}
+
+// thermometer holds digits that strictly increase from the bulb, line[0]
+type thermometer struct {
+	line []int
+	size int
+}
+
+func (t *thermometer) Cells() []int {
+	return t.line
+}
+
+// allowed leaves room for the cells between this one and each filled cell
+func (t *thermometer) Allowed(values []int, cell int) uint32 {
+	i := 0
+	for i < len(t.line) && t.line[i] != cell {
+		i++
+	}
+
+	lo, hi := i+1, t.size-(len(t.line)-1-i)
+	for j, other := range t.line {
+		v := values[other]
+		switch {
+		case v == 0 || j == i:
+		case j < i && v+i-j > lo:
+			lo = v + i - j
+		case j > i && v-(j-i) < hi:
+			hi = v - (j - i)
+		}
+	}
+	return digitRange(lo, hi, t.size)
+}
+
+func (t *thermometer) Technique() Technique {
+	return TechniqueConstraint
+}
+
+func (t *thermometer) Describe(cellName func(cell int) string) string {
+	return fmt.Sprintf("the thermometer at %s", cellName(t.line[0]))
+}
+
+// arrow has a circle holding the sum of the digits along its shaft. Digits
+// on the shaft may repeat unless a unit forbids it.
+type arrow struct {
+	circle int
+	shaft  []int
+	size   int
+}
+
+func (a *arrow) Cells() []int {
+	return append([]int{a.circle}, a.shaft...)
+}
+
+// allowed bounds a digit by the smallest and largest sums the open cells
+// can still make
+func (a *arrow) Allowed(values []int, cell int) uint32 {
+	sum, open := 0, 0
+	for _, other := range a.shaft {
+		if other == cell {
+			continue
+		}
+		if v := values[other]; v != 0 {
+			sum += v
+		} else {
+			open++
+		}
+	}
+
+	if cell == a.circle {
+		return digitRange(sum+open, sum+open*a.size, a.size)
+	}
+	if circle := values[a.circle]; circle != 0 {
+		return digitRange(circle-sum-open*a.size, circle-sum-open, a.size)
+	}
+	return digitRange(1, a.size-sum-open, a.size)
+}
+
+func (a *arrow) Technique() Technique {
+	return TechniqueConstraint
+}
+
+func (a *arrow) Describe(cellName func(cell int) string) string {
+	return fmt.Sprintf("the arrow from %s", cellName(a.circle))
+}
+
+// pairRule relates the digits of two cells, e.g. a Kropki dot or an X
+type pairRule struct {
+	pair    [2]int
+	name    string
+	fits    func(a, b int) bool
+	size    int
+	partner uint32 // digits that fit with at least one other digit
+}
+
+func newPairRule(lay *layout, name string, fits func(a, b int) bool, a, b int) *pairRule {
+	p := &pairRule{pair: [2]int{a, b}, name: name, fits: fits, size: lay.size}
+	for x := 1; x <= lay.size; x++ {
+		for y := 1; y <= lay.size; y++ {
+			if x != y && fits(x, y) {
+				p.partner |= 1 << uint(x)
+			}
+		}
+	}
+	return p
+}
+
+// pairBuilder builds a pair rule between two cells sharing an edge
+func pairBuilder(name string, fits func(a, b int) bool) constraintBuilder {
+	return func(lay *layout, cells []int) ([]Constraint, error) {
+		if len(cells) != 2 {
+			return nil, invalidPuzzle("a %s needs 2 cells, not %d", name, len(cells))
+		}
+		if rows, cols := cellDistance(lay, cells[0], cells[1]); rows+cols != 1 {
+			return nil, invalidPuzzle("%s and %s don't share an edge", lay.cellName(cells[0]), lay.cellName(cells[1]))
+		}
+		return []Constraint{newPairRule(lay, name, fits, cells[0], cells[1])}, nil
+	}
+}
+
+func (p *pairRule) Cells() []int {
+	return p.pair[:]
+}
+
+func (p *pairRule) Allowed(values []int, cell int) uint32 {
+	other := p.pair[0]
+	if other == cell {
+		other = p.pair[1]
+	}
+	v := values[other]
+	if v == 0 {
+		return p.partner
+	}
+
+	var mask uint32
+	for digit := 1; digit <= p.size; digit++ {
+		if p.fits(digit, v) {
+			mask |= 1 << uint(digit)
+		}
+	}
+	return mask
+}
+
+func (p *pairRule) Technique() Technique {
+	return TechniqueConstraint
+}
+
+func (p *pairRule) Describe(cellName func(cell int) string) string {
+	return fmt.Sprintf("the %s between %s and %s", p.name, cellName(p.pair[0]), cellName(p.pair[1]))
+}
+
+// parityRule limits a cell to even or odd digits
+type parityRule struct {
+	cell []int
+	name string
+	mask uint32
+}
+
+// parityBuilder builds a parity rule for each cell, keeping the digits whose
+// remainder when divided by 2 is rem
+func parityBuilder(name string, rem int) constraintBuilder {
+	return func(lay *layout, cells []int) ([]Constraint, error) {
+		var mask uint32
+		for digit := 1; digit <= lay.size; digit++ {
+			if digit%2 == rem {
+				mask |= 1 << uint(digit)
+			}
+		}
+		rules := make([]Constraint, 0, len(cells))
+		for _, cell := range cells {
+			rules = append(rules, &parityRule{cell: []int{cell}, name: name, mask: mask})
+		}
+		return rules, nil
+	}
+}
+
+func (p *parityRule) Cells() []int {
+	return p.cell
+}
+
+func (p *parityRule) Allowed(values []int, cell int) uint32 {
+	return p.mask
+}
+
+func (p *parityRule) Technique() Technique {
+	return TechniqueConstraint
+}
+
+func (p *parityRule) Describe(cellName func(cell int) string) string {
+	return fmt.Sprintf("the %s cell %s", p.name, cellName(p.cell[0]))
+}
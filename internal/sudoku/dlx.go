@@ -0,0 +1,303 @@
+package sudoku
+
+import (
+	"context"
+	"math/bits"
+)
+
+// Dancing Links (Algorithm X) backend.
+//
+// A board is encoded as an exact-cover problem: every empty cell must hold
+// exactly one digit and every unit must hold every digit exactly once. Each
+// candidate placement is a matrix row that covers one cell column and one
+// column per unit containing the cell. Givens are selected before the search
+// starts, so uniqueness checks only explore the open part of the board.
+
+// dlxMatrix is a sparse exact-cover matrix stored as parallel slices. Node 0
+// is the root and nodes 1..columns are the column headers.
+type dlxMatrix struct {
+	left, right, up, down []int
+	column                []int // header of each node
+	count                 []int // live nodes per column, indexed by header
+	rowOf                 []int // placement (cell*size + digit-1) of each node
+	covered               []bool
+
+	partial []int // placements chosen so far
+}
+
+func newDLXMatrix(columns int) *dlxMatrix {
+	m := &dlxMatrix{covered: make([]bool, columns+1)}
+	for i := 0; i <= columns; i++ {
+		m.left = append(m.left, i-1)
+		m.right = append(m.right, i+1)
+		m.up = append(m.up, i)
+		m.down = append(m.down, i)
+		m.column = append(m.column, i)
+		m.count = append(m.count, 0)
+		m.rowOf = append(m.rowOf, -1)
+	}
+	m.left[0] = columns
+	m.right[columns] = 0
+	return m
+}
+
+// addRow appends a row covering the given 0-based columns and returns its first node
+func (m *dlxMatrix) addRow(row int, columns []int) int {
+	first := -1
+	for _, c := range columns {
+		h := c + 1
+		n := len(m.left)
+		m.column = append(m.column, h)
+		m.rowOf = append(m.rowOf, row)
+		m.up = append(m.up, m.up[h])
+		m.down = append(m.down, h)
+		m.down[m.up[h]] = n
+		m.up[h] = n
+		m.count[h]++
+
+		if first < 0 {
+			m.left = append(m.left, n)
+			m.right = append(m.right, n)
+			first = n
+		} else {
+			m.left = append(m.left, m.left[first])
+			m.right = append(m.right, first)
+			m.right[m.left[first]] = n
+			m.left[first] = n
+		}
+	}
+	return first
+}
+
+func (m *dlxMatrix) cover(h int) {
+	m.covered[h] = true
+	m.right[m.left[h]] = m.right[h]
+	m.left[m.right[h]] = m.left[h]
+	for i := m.down[h]; i != h; i = m.down[i] {
+		for j := m.right[i]; j != i; j = m.right[j] {
+			m.down[m.up[j]] = m.down[j]
+			m.up[m.down[j]] = m.up[j]
+			m.count[m.column[j]]--
+		}
+	}
+}
+
+func (m *dlxMatrix) uncover(h int) {
+	for i := m.up[h]; i != h; i = m.up[i] {
+		for j := m.left[i]; j != i; j = m.left[j] {
+			m.count[m.column[j]]++
+			m.down[m.up[j]] = j
+			m.up[m.down[j]] = j
+		}
+	}
+	m.right[m.left[h]] = h
+	m.left[m.right[h]] = h
+	m.covered[h] = false
+}
+
+// selectRow commits to the row containing node and reports false if it
+// clashes with a row selected earlier
+func (m *dlxMatrix) selectRow(node int) bool {
+	j := node
+	for {
+		if m.covered[m.column[j]] {
+			return false
+		}
+		j = m.right[j]
+		if j == node {
+			break
+		}
+	}
+
+	j = node
+	for {
+		m.cover(m.column[j])
+		j = m.right[j]
+		if j == node {
+			break
+		}
+	}
+	m.partial = append(m.partial, m.rowOf[node])
+	return true
+}
+
+// search runs Algorithm X, always branching on the column with the fewest
+// rows. It follows the same visit and budget contract as board.search.
+func (m *dlxMatrix) search(bg *budget, visit func() bool) bool {
+	if !bg.spend() {
+		return false
+	}
+	if m.right[0] == 0 {
+		return visit()
+	}
+
+	best, bestCount := -1, len(m.left)
+	for h := m.right[0]; h != 0; h = m.right[h] {
+		if m.count[h] < bestCount {
+			best, bestCount = h, m.count[h]
+			if bestCount <= 1 {
+				break
+			}
+		}
+	}
+	if bestCount == 0 {
+		return true
+	}
+
+	m.cover(best)
+	for r := m.down[best]; r != best; r = m.down[r] {
+		m.partial = append(m.partial, m.rowOf[r])
+		for j := m.right[r]; j != r; j = m.right[j] {
+			m.cover(m.column[j])
+		}
+
+		ok := m.search(bg, visit)
+
+		for j := m.left[r]; j != r; j = m.left[j] {
+			m.uncover(m.column[j])
+		}
+		m.partial = m.partial[:len(m.partial)-1]
+
+		if !ok {
+			m.uncover(best)
+			return false
+		}
+	}
+	m.uncover(best)
+	return true
+}
+
+// newDLXFromBoard builds the exact-cover matrix for b with its filled cells
+// already selected. It returns false if the filled cells conflict.
+func newDLXFromBoard(b *board) (*dlxMatrix, bool) {
+	lay := b.lay
+	cellColumns := len(b.cells)
+	m := newDLXMatrix(cellColumns + len(lay.units)*lay.size)
+
+	var givens []int
+	columns := make([]int, 0, 4)
+	for cell, v := range b.cells {
+		var mask uint32
+		if v == 0 {
+			mask = b.candidates(cell)
+		} else if v > 0 && v <= lay.size {
+			mask = 1 << uint(v)
+		} else {
+			return nil, false
+		}
+
+		for ; mask != 0; mask &= mask - 1 {
+			digit := bits.TrailingZeros32(mask)
+			columns = append(columns[:0], cell)
+			for _, u := range lay.cellUnits[cell] {
+				columns = append(columns, cellColumns+u*lay.size+digit-1)
+			}
+			node := m.addRow(cell*lay.size+digit-1, columns)
+			if v != 0 {
+				givens = append(givens, node)
+			}
+		}
+	}
+
+	for _, node := range givens {
+		if !m.selectRow(node) {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// fill writes the selected placements back onto b
+func (m *dlxMatrix) fill(b *board) {
+	for _, row := range m.partial {
+		b.cells[row/b.lay.size] = row%b.lay.size + 1
+	}
+}
+
+// DLXSolver solves boards as exact-cover problems using Dancing Links. It is
+// much faster than plain backtracking at proving uniqueness, which dominates
+// puzzle generation. Extra regions are just more units, so it handles them
+// too, but not constraints such as killer cages or the chess rules.
+type DLXSolver struct {
+	rules *rules // nil for the classic rules
+}
+
+// newBoard builds a board under the solver's rules
+func (s DLXSolver) newBoard(grid PuzzleGrid) *board {
+	if s.rules == nil {
+		return newBoard(grid)
+	}
+	return newBoardFor(s.rules, grid)
+}
+
+// forRules returns a DLX solver for r if r has no constraints or chess rules
+// beyond its units
+func (DLXSolver) forRules(r *rules) (Solver, bool) {
+	if len(r.constraints) > 0 || r.lay.antiKnight || r.lay.antiKing {
+		return nil, false
+	}
+	return DLXSolver{rules: r}, true
+}
+
+// Name returns the backend name
+func (DLXSolver) Name() string {
+	return "dlx"
+}
+
+// Solve fills grid in place with the first solution found
+func (s DLXSolver) Solve(ctx context.Context, grid *PuzzleGrid, limits SearchLimits) (bool, error) {
+	limits.MaxSolutions = 1
+	solutions, err := s.Solutions(ctx, *grid, limits)
+	if len(solutions) == 0 {
+		return false, err
+	}
+	*grid = solutions[0]
+	return true, nil
+}
+
+// CountSolutions returns the number of solutions of grid, up to limits.MaxSolutions
+func (s DLXSolver) CountSolutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (int, error) {
+	m, ok := newDLXFromBoard(s.newBoard(grid))
+	if !ok {
+		return 0, nil
+	}
+
+	bg, cancel := newBudget(ctx, limits)
+	defer cancel()
+
+	count := 0
+	m.search(bg, func() bool {
+		count++
+		return limits.wantsMore(count)
+	})
+	return count, bg.result(count)
+}
+
+// Candidates returns the digits not ruled out by a filled peer for each empty cell
+func (s DLXSolver) Candidates(grid PuzzleGrid) CandidateGrid {
+	return NativeSolver{rules: s.rules}.Candidates(grid)
+}
+
+// Solutions returns up to limits.MaxSolutions distinct solutions of grid
+func (s DLXSolver) Solutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) ([]PuzzleGrid, error) {
+	b := s.newBoard(grid)
+	m, ok := newDLXFromBoard(b)
+	if !ok {
+		return nil, nil
+	}
+
+	bg, cancel := newBudget(ctx, limits)
+	defer cancel()
+
+	var solutions []PuzzleGrid
+	m.search(bg, func() bool {
+		m.fill(b)
+		solutions = append(solutions, b.grid())
+		return limits.wantsMore(len(solutions))
+	})
+	return solutions, bg.result(len(solutions))
+}
+
+func init() {
+	RegisterSolver(DLXSolver{})
+}
@@ -0,0 +1,106 @@
+package sudoku
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// CandidateGrid holds the digits that can legally be placed in each cell.
+// Filled cells have no candidates.
+type CandidateGrid [MaxGridSize][MaxGridSize][]int
+
+// Solver is implemented by every solving backend. Puzzle generation and
+// validation only ever talk to the active Solver, so backends can be swapped
+// without touching the rest of the package.
+type Solver interface {
+	// Name identifies the backend in logs and on the command line
+	Name() string
+	// Solve fills grid in place with a solution and reports whether one exists
+	Solve(ctx context.Context, grid *PuzzleGrid, limits SearchLimits) (bool, error)
+	// CountSolutions returns the number of solutions of grid, up to limits.MaxSolutions
+	CountSolutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (int, error)
+	// Candidates returns the digits that can legally go in each empty cell
+	Candidates(grid PuzzleGrid) CandidateGrid
+}
+
+// Enumerator is implemented by backends that can list distinct solutions
+type Enumerator interface {
+	// Solutions returns up to limits.MaxSolutions distinct solutions of grid
+	Solutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) ([]PuzzleGrid, error)
+}
+
+// variantSolver is implemented by backends that can search under variant
+// rules. forRules returns a copy of the backend using r, or false if the
+// backend can't express r.
+type variantSolver interface {
+	forRules(r *rules) (Solver, bool)
+}
+
+var (
+	solvers             = map[string]Solver{}
+	activeSolver Solver = NativeSolver{}
+	solverMutex  sync.RWMutex
+)
+
+func init() {
+	RegisterSolver(NativeSolver{})
+}
+
+// RegisterSolver makes a backend available to SetSolver
+func RegisterSolver(solver Solver) {
+	solverMutex.Lock()
+	defer solverMutex.Unlock()
+
+	solvers[solver.Name()] = solver
+}
+
+// SetSolver selects the backend used for generation, solving and validation
+func SetSolver(name string) error {
+	solverMutex.Lock()
+	defer solverMutex.Unlock()
+
+	solver, ok := solvers[name]
+	if !ok {
+		return fmt.Errorf("unknown solver %q (available: %v)", name, solverNamesLocked())
+	}
+
+	activeSolver = solver
+	utils.Log(utils.LogLevelInfo, "Using %s solver backend", name)
+	return nil
+}
+
+// ActiveSolver returns the currently selected backend
+func ActiveSolver() Solver {
+	solverMutex.RLock()
+	defer solverMutex.RUnlock()
+	return activeSolver
+}
+
+// SolverNames returns the names of all registered backends
+func SolverNames() []string {
+	solverMutex.RLock()
+	defer solverMutex.RUnlock()
+	return solverNamesLocked()
+}
+
+func solverNamesLocked() []string {
+	names := make([]string, 0, len(solvers))
+	for name := range solvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EnumerateSolutions lists up to limits.MaxSolutions solutions of grid using
+// the active backend, falling back to the native solver if it cannot enumerate
+func EnumerateSolutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) ([]PuzzleGrid, error) {
+	if enumerator, ok := ActiveSolver().(Enumerator); ok {
+		return enumerator.Solutions(ctx, grid, limits)
+	}
+	return NativeSolver{}.Solutions(ctx, grid, limits)
+}
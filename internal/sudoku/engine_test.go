@@ -0,0 +1,79 @@
+package sudoku
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBackendsAgree(t *testing.T) {
+	diagonalLayout, err := classicLayout.withExtraRegions([]string{RegionDiagonal})
+	if err != nil {
+		t.Fatalf("withExtraRegions: %v", err)
+	}
+	diagonal := newRules(diagonalLayout, nil)
+	six, err := rulesForSize(6)
+	if err != nil {
+		t.Fatalf("rulesForSize: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		rules *rules
+		grid  string
+		want  int // Solutions, capped at the limit
+	}{
+		{"unique", classicRules, classicPuzzle, 1},
+		{"ambiguous", classicRules, strings.Repeat("0", 18) + classicPuzzle[18:], 10},
+		{"no solution", classicRules, "55" + classicPuzzle[2:], 0},
+		{"empty", classicRules, strings.Repeat("0", 81), 10},
+		{"diagonal", diagonal, strings.Repeat("0", 18) + classicPuzzle[18:], 0},
+		{"diagonal empty", diagonal, strings.Repeat("0", 81), 10},
+		{"6x6", six, "120000340000000000000000000000000000", 10},
+		{"6x6 broken", six, "120000000340005000000006000000000021", 0},
+	}
+	limits := SearchLimits{MaxSolutions: 10}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grid := parseGrid(t, tt.grid, tt.rules.lay.width)
+			native, dlx := NativeSolver{rules: tt.rules}, DLXSolver{rules: tt.rules}
+
+			nativeCount, nativeErr := native.CountSolutions(context.Background(), grid, limits)
+			dlxCount, dlxErr := dlx.CountSolutions(context.Background(), grid, limits)
+			if nativeCount != tt.want || dlxCount != tt.want || (nativeErr == nil) != (dlxErr == nil) {
+				t.Errorf("native counts %d (%v), dlx %d (%v), want %d", nativeCount, nativeErr, dlxCount, dlxErr, tt.want)
+			}
+
+			nativeSolutions, _ := native.Solutions(context.Background(), grid, limits)
+			dlxSolutions, _ := dlx.Solutions(context.Background(), grid, limits)
+			if len(nativeSolutions) != nativeCount || len(dlxSolutions) != dlxCount {
+				t.Errorf("listed %d and %d solutions, counted %d and %d",
+					len(nativeSolutions), len(dlxSolutions), nativeCount, dlxCount)
+			}
+			for _, solution := range dlxSolutions {
+				if broken := tt.rules.broken(solution); !newBoardFor(tt.rules, solution).consistent() || len(broken) > 0 {
+					t.Errorf("dlx solution breaks the rules:\n%s", PrintGrid(solution, tt.rules.lay.width))
+				}
+			}
+			if nativeCount == 1 && nativeSolutions[0] != dlxSolutions[0] {
+				t.Errorf("backends found different unique solutions")
+			}
+		})
+	}
+}
+
+func TestSetSolver(t *testing.T) {
+	defer SetSolver(ActiveSolver().Name())
+
+	for _, name := range []string{"dlx", "native"} {
+		if err := SetSolver(name); err != nil {
+			t.Fatalf("SetSolver(%q): %v", name, err)
+		}
+		if got := ActiveSolver().Name(); got != name {
+			t.Errorf("active solver %q, want %q", got, name)
+		}
+	}
+	if err := SetSolver("abacus"); err == nil {
+		t.Errorf("SetSolver accepted an unknown backend")
+	}
+}
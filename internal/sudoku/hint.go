@@ -0,0 +1,169 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// Hint levels, from the gentlest nudge to the full answer
+const (
+	HintRegion    = 1 // Only the region to look at
+	HintTechnique = 2 // The technique and the cells it relies on
+	HintAnswer    = 3 // The full deduction, including placements and eliminations
+)
+
+// ErrNoSolution is returned when a puzzle's system cells have no solution
+var ErrNoSolution = errors.New("puzzle has no solution")
+
+// Hint describes the next deduction a player could make
+type Hint struct {
+	Level        int           `json:"level"`
+	Message      string        `json:"message"`
+	Units        []string      `json:"units,omitempty"`
+	Technique    Technique     `json:"technique,omitempty"`
+	Cells        []string      `json:"cells,omitempty"`
+	Placements   []Placement   `json:"placements,omitempty"`
+	Eliminations []Elimination `json:"eliminations,omitempty"`
+	Steps        []Step        `json:"steps,omitempty"`    // Every deduction leading up to the placement
+	Mistakes     []string      `json:"mistakes,omitempty"` // User cells that don't match the solution
+}
+
+// NextHint works out the next logical deduction from the player's current
+// cells. Candidate eliminations aren't visible in the cell values, so the
+// hint follows the chain of deductions up to the next placement and reports
+// the hardest technique used on the way. Wrong entries are reported instead
+// of a deduction, since no logic can be built on them.
+func NextHint(ctx context.Context, puzzle models.Puzzle, level int, limits SearchLimits) (Hint, error) {
+	if level < HintRegion {
+		level = HintRegion
+	} else if level > HintAnswer {
+		level = HintAnswer
+	}
+	hint := Hint{Level: level}
+
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return hint, err
+	}
+	solution, err := solutionFor(ctx, puzzle, limits)
+	if err != nil {
+		return hint, err
+	}
+
+	// Check the user's entries before deducing anything from them
+	lay := r.lay
+	current := cellsToGrid(puzzle.Cells, lay.width)
+	var mistakes []int
+	for cell := 0; cell < lay.numCells(); cell++ {
+		row, col := lay.rowCol(cell)
+		if v := current[row][col]; v != 0 && v != solution[row][col] {
+			mistakes = append(mistakes, cell)
+		}
+	}
+	if len(mistakes) > 0 {
+		return mistakeHint(lay, hint, mistakes), nil
+	}
+
+	st := newLogicState(r, current)
+	if st.solved() {
+		hint.Message = "The puzzle is already solved"
+		return hint, nil
+	}
+
+	var steps []Step
+	hardestIndex := 0
+	for !st.solved() {
+		d := st.next()
+		if d == nil {
+			break
+		}
+		step := st.step(d)
+		if len(steps) > 0 && techniqueScores[step.Technique] > techniqueScores[steps[hardestIndex].Technique] {
+			hardestIndex = len(steps)
+		}
+		steps = append(steps, step)
+		st.apply(d)
+		if len(d.placements) > 0 {
+			break
+		}
+	}
+
+	if len(steps) == 0 || len(steps[len(steps)-1].Placements) == 0 {
+		return revealHint(lay, hint, current, solution), nil
+	}
+
+	final, hardest := steps[len(steps)-1], steps[hardestIndex]
+	hint.Units = final.Units
+	if len(hint.Units) == 0 {
+		hint.Units = []string{lay.unitNames[unitOfKind(lay, lay.cellOf(final.Placements[0].Cell), unitBox)]}
+	}
+	hint.Message = fmt.Sprintf("Look at %s", hint.Units[0])
+
+	if level >= HintTechnique {
+		hint.Technique = hardest.Technique
+		hint.Cells = hardest.Cells
+		hint.Message = fmt.Sprintf("Look at %s and try a %s", hint.Units[0], hardest.Technique)
+	}
+
+	if level >= HintAnswer {
+		hint.Steps = steps
+		hint.Placements = final.Placements
+		for _, step := range steps {
+			hint.Eliminations = append(hint.Eliminations, step.Eliminations...)
+		}
+		hint.Message = final.Description
+	}
+
+	utils.Log(utils.LogLevelDebug, "Hint at level %d uses %d steps, hardest %s", level, len(steps), hardest.Technique)
+	return hint, nil
+}
+
+// mistakeHint points the player at their wrong entries
+func mistakeHint(lay *layout, hint Hint, mistakes []int) Hint {
+	box := unitOfKind(lay, mistakes[0], unitBox)
+	hint.Units = []string{lay.unitNames[box]}
+
+	if hint.Level < HintTechnique {
+		hint.Message = fmt.Sprintf("There is a mistake in %s", hint.Units[0])
+		return hint
+	}
+
+	for _, cell := range mistakes {
+		hint.Mistakes = append(hint.Mistakes, lay.key(cell))
+	}
+	hint.Message = fmt.Sprintf("%d of your entries are wrong", len(mistakes))
+	if len(mistakes) == 1 {
+		hint.Message = fmt.Sprintf("%s is wrong", lay.cellName(mistakes[0]))
+	}
+	return hint
+}
+
+// revealHint is given when logic alone gets no further
+func revealHint(lay *layout, hint Hint, current, solution PuzzleGrid) Hint {
+	hint.Message = "No further logical deduction is available"
+	if hint.Level < HintAnswer {
+		return hint
+	}
+
+	for cell := 0; cell < lay.numCells(); cell++ {
+		row, col := lay.rowCol(cell)
+		if current[row][col] == 0 {
+			hint.Placements = []Placement{{Cell: lay.key(cell), Value: solution[row][col]}}
+			hint.Message = fmt.Sprintf("No further logical deduction is available; %s is %d",
+				lay.cellName(cell), solution[row][col])
+			break
+		}
+	}
+	return hint
+}
+
+// cellIndex converts a position key (01-81 on a 9x9 grid) to a 0-based board position
+func cellIndex(posKey string) int {
+	pos, _ := strconv.Atoi(posKey)
+	return pos - 1
+}
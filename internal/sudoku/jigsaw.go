@@ -0,0 +1,137 @@
+package sudoku
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Jigsaw Sudoku replaces the 3x3 boxes with irregular regions: any nine
+// orthogonally connected groups of nine cells. The regions are stored as a
+// region map giving the region (1-9) of each cell, row by row.
+
+// jigsawSwaps is how many cells makeJigsawRegions swaps between regions.
+// More swaps give more irregular regions.
+const jigsawSwaps = 80
+
+// newJigsawLayout builds a 9x9 layout whose boxes are the regions of regionMap
+func newJigsawLayout(regionMap []int) (*layout, error) {
+	const size = 9
+	if err := checkRegionMap(size, regionMap); err != nil {
+		return nil, err
+	}
+
+	regions := make([][]int, size)
+	for cell, region := range regionMap {
+		regions[region-1] = append(regions[region-1], cell)
+	}
+
+	lay := newLineLayout(size)
+	for i, region := range regions {
+		lay.addUnit(unitBox, fmt.Sprintf("region %d", i+1), region)
+	}
+	lay.regionMap = append([]int{}, regionMap...)
+	lay.index()
+	return lay, nil
+}
+
+// checkRegionMap checks that a region map divides the grid into size
+// connected regions of size cells each
+func checkRegionMap(size int, regionMap []int) error {
+	if len(regionMap) != size*size {
+		return invalidPuzzle("region map has %d cells, expected %d", len(regionMap), size*size)
+	}
+
+	counts := make([]int, size+1)
+	for cell, region := range regionMap {
+		if region < 1 || region > size {
+			return invalidPuzzle("cell %s has invalid region %d", cellKey(cell), region)
+		}
+		counts[region]++
+	}
+	for region := 1; region <= size; region++ {
+		if counts[region] != size {
+			return invalidPuzzle("region %d has %d cells, expected %d", region, counts[region], size)
+		}
+		if !regionConnected(regionMap, region) {
+			return invalidPuzzle("region %d is not connected", region)
+		}
+	}
+	return nil
+}
+
+// regionConnected reports whether the cells of a region form one
+// orthogonally connected group
+func regionConnected(regionMap []int, region int) bool {
+	start, size := -1, 0
+	for cell, r := range regionMap {
+		if r == region {
+			if start < 0 {
+				start = cell
+			}
+			size++
+		}
+	}
+	if start < 0 {
+		return false
+	}
+
+	seen := map[int]bool{start: true}
+	queue := []int{start}
+	for len(queue) > 0 {
+		cell := queue[0]
+		queue = queue[1:]
+		for _, next := range orthogonalNeighbours(cell) {
+			if regionMap[next] == region && !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return len(seen) == size
+}
+
+// makeJigsawRegions builds a random region map by starting from the 3x3
+// boxes and trading cells between neighbouring regions: a cell on the
+// border of region A moves into its neighbour B, and a cell of B touching A
+// moves the other way. Trades that disconnect either region are undone.
+func makeJigsawRegions(rng *rand.Rand) []int {
+	regionMap := make([]int, 81)
+	for cell := range regionMap {
+		regionMap[cell] = (cell/27)*3 + (cell%9)/3 + 1
+	}
+
+	for swaps := 0; swaps < jigsawSwaps; {
+		a := rng.Intn(81)
+		neighbours := orthogonalNeighbours(a)
+		from, to := regionMap[a], regionMap[neighbours[rng.Intn(len(neighbours))]]
+		if from == to {
+			continue
+		}
+
+		// Cells of the other region that could move back in exchange
+		var back []int
+		for cell, region := range regionMap {
+			if region != to {
+				continue
+			}
+			for _, next := range orthogonalNeighbours(cell) {
+				if next != a && regionMap[next] == from {
+					back = append(back, cell)
+					break
+				}
+			}
+		}
+		if len(back) == 0 {
+			continue
+		}
+		b := back[rng.Intn(len(back))]
+
+		regionMap[a], regionMap[b] = to, from
+		if regionConnected(regionMap, from) && regionConnected(regionMap, to) {
+			swaps++
+		} else {
+			regionMap[a], regionMap[b] = from, to
+		}
+	}
+	return regionMap
+}
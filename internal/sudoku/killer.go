@@ -0,0 +1,240 @@
+package sudoku
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sort"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// Killer Sudoku cages: groups of cells whose digits don't repeat and add up
+// to the cage's sum. Cages are constraints rather than units, since they
+// don't have to hold every digit.
+
+// maxCageSum is the sum of the digits 1-9
+const maxCageSum = 45
+
+// cageDigits[n][sum][used] is the set of digits (bit d-1 for digit d) that
+// appear in some combination of n distinct digits adding up to sum and
+// avoiding the digits in used
+var cageDigits = buildCageDigits()
+
+func buildCageDigits() *[10][maxCageSum + 1][512]uint16 {
+	table := new([10][maxCageSum + 1][512]uint16)
+	for combo := 0; combo < 512; combo++ {
+		n, sum := bits.OnesCount(uint(combo)), 0
+		for digit := 1; digit <= 9; digit++ {
+			if combo&(1<<uint(digit-1)) != 0 {
+				sum += digit
+			}
+		}
+
+		// Record the combination under every set of used digits it avoids
+		free := 511 &^ combo
+		for used := free; ; used = (used - 1) & free {
+			table[n][sum][used] |= uint16(combo)
+			if used == 0 {
+				break
+			}
+		}
+	}
+	return table
+}
+
+// cageSizes are the cage sizes the generator picks from, small cages being
+// the most common as in published puzzles
+var cageSizes = []int{2, 2, 2, 3, 3, 3, 3, 4, 4, 5}
+
+// maxMergedCageSize caps how large a cage can grow when a leftover single
+// cell is merged into it
+const maxMergedCageSize = 6
+
+// cage is a killer cage over cell indexes
+type cage struct {
+	cageCells []int
+	sum       int
+}
+
+func (c *cage) Cells() []int {
+	return c.cageCells
+}
+
+// allowed returns the digits that complete some combination of the cage
+// given the digits already placed in its other cells
+func (c *cage) Allowed(values []int, cell int) uint32 {
+	var used uint32
+	remaining, open := c.sum, 0
+	for _, other := range c.cageCells {
+		v := values[other]
+		if other == cell || v == 0 {
+			open++
+			continue
+		}
+		if used&(1<<uint(v)) != 0 || v > 9 {
+			return 0
+		}
+		used |= 1 << uint(v)
+		remaining -= v
+	}
+	if remaining < 0 || remaining > maxCageSum || open > 9 {
+		return 0
+	}
+	return uint32(cageDigits[open][remaining][used>>1]) << 1
+}
+
+func (c *cage) Technique() Technique {
+	return TechniqueCageSum
+}
+
+func (c *cage) Describe(cellName func(cell int) string) string {
+	return fmt.Sprintf("the %d cage at %s", c.sum, cellName(c.cageCells[0]))
+}
+
+// cagesFromModel checks and converts the cages of a puzzle
+func cagesFromModel(lay *layout, modelCages []models.Cage) ([]Constraint, error) {
+	if lay.size > 9 {
+		return nil, invalidPuzzle("killer cages need digits up to 9, not %d", lay.size)
+	}
+
+	owner := make(map[int]int)
+	cages := make([]Constraint, 0, len(modelCages))
+	for i, mc := range modelCages {
+		if len(mc.Cells) == 0 || len(mc.Cells) > lay.size {
+			return nil, invalidPuzzle("cage %d has %d cells", i+1, len(mc.Cells))
+		}
+
+		c := &cage{sum: mc.Sum}
+		for _, posKey := range mc.Cells {
+			cell := cellIndex(posKey)
+			if cell < 0 || cell >= lay.size*lay.size {
+				return nil, invalidPuzzle("cage %d has invalid cell %q", i+1, posKey)
+			}
+			if other, ok := owner[cell]; ok {
+				return nil, invalidPuzzle("cell %s is in cages %d and %d", lay.cellName(cell), other+1, i+1)
+			}
+			owner[cell] = i
+			c.cageCells = append(c.cageCells, cell)
+		}
+
+		n := len(c.cageCells)
+		if c.sum > maxCageSum || cageDigits[n][c.sum][0] == 0 {
+			return nil, invalidPuzzle("cage %d can't add up to %d with %d cells", i+1, c.sum, n)
+		}
+		cages = append(cages, c)
+	}
+	return cages, nil
+}
+
+// cagesToModel converts cages to their stored form
+func cagesToModel(cages []*cage) []models.Cage {
+	modelCages := make([]models.Cage, 0, len(cages))
+	for _, c := range cages {
+		mc := models.Cage{Sum: c.sum, Cells: make([]string, 0, len(c.cageCells))}
+		for _, cell := range c.cageCells {
+			mc.Cells = append(mc.Cells, cellKey(cell))
+		}
+		modelCages = append(modelCages, mc)
+	}
+	return modelCages
+}
+
+// makeCages divides a solution grid into random cages of orthogonally
+// connected cells without repeated digits. Cells left on their own are
+// merged into a neighbouring cage where possible.
+func makeCages(grid PuzzleGrid, rng *rand.Rand) []*cage {
+	owner := make([]int, 81)
+	for cell := range owner {
+		owner[cell] = -1
+	}
+	value := func(cell int) int { return grid[cell/9][cell%9] }
+
+	var cages []*cage
+	for _, start := range rng.Perm(81) {
+		if owner[start] >= 0 {
+			continue
+		}
+
+		c := &cage{cageCells: []int{start}, sum: value(start)}
+		owner[start] = len(cages)
+		used := uint32(1) << uint(value(start))
+		for size := cageSizes[rng.Intn(len(cageSizes))]; len(c.cageCells) < size; {
+			var options []int
+			for _, cell := range c.cageCells {
+				for _, next := range orthogonalNeighbours(cell) {
+					if owner[next] < 0 && used&(1<<uint(value(next))) == 0 && !intsContain(options, next) {
+						options = append(options, next)
+					}
+				}
+			}
+			if len(options) == 0 {
+				break
+			}
+
+			next := options[rng.Intn(len(options))]
+			c.cageCells = append(c.cageCells, next)
+			c.sum += value(next)
+			owner[next] = len(cages)
+			used |= 1 << uint(value(next))
+		}
+		cages = append(cages, c)
+	}
+
+	// Merge single cells into a neighbouring cage that doesn't hold their digit
+	for _, c := range cages {
+		if len(c.cageCells) != 1 {
+			continue
+		}
+		cell := c.cageCells[0]
+		for _, next := range orthogonalNeighbours(cell) {
+			target := cages[owner[next]]
+			if target == c || len(target.cageCells) >= maxMergedCageSize || cageHolds(target, grid, value(cell)) {
+				continue
+			}
+			target.cageCells = append(target.cageCells, cell)
+			target.sum += value(cell)
+			owner[cell] = owner[next]
+			c.cageCells = nil
+			break
+		}
+	}
+
+	kept := make([]*cage, 0, len(cages))
+	for _, c := range cages {
+		if len(c.cageCells) > 0 {
+			sort.Ints(c.cageCells)
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// cageHolds reports whether a cage already holds digit in the solution grid
+func cageHolds(c *cage, grid PuzzleGrid, digit int) bool {
+	for _, cell := range c.cageCells {
+		if grid[cell/9][cell%9] == digit {
+			return true
+		}
+	}
+	return false
+}
+
+// orthogonalNeighbours returns the cells directly above, below, left and right of cell
+func orthogonalNeighbours(cell int) []int {
+	row, col := cell/9, cell%9
+	var neighbours []int
+	if row > 0 {
+		neighbours = append(neighbours, cell-9)
+	}
+	if row < 8 {
+		neighbours = append(neighbours, cell+9)
+	}
+	if col > 0 {
+		neighbours = append(neighbours, cell-1)
+	}
+	if col < 8 {
+		neighbours = append(neighbours, cell+1)
+	}
+	return neighbours
+}
@@ -0,0 +1,153 @@
+//go:build cgo && libsudoku
+
+package sudoku
+
+/*
+#cgo CFLAGS: -g -Wall
+#cgo LDFLAGS: -L../../c -lsudoku
+#include <stdio.h>
+#include <stdbool.h>
+#include "../../sudoku.h"
+*/
+import "C"
+import (
+	"context"
+	"unsafe"
+)
+
+// cGrid is the memory layout libsudoku expects
+type cGrid [9][9]C.int
+
+func init() {
+	RegisterSolver(LibSudokuSolver{})
+}
+
+// LibSudokuSolver wraps the C solver in c/sudoku.c. It is only compiled in
+// with `-tags libsudoku` and requires libsudoku to be available at link time.
+// The C search runs a slice of nodes at a time, so that the limits and the
+// context can be checked between slices.
+type LibSudokuSolver struct {
+	rules   *rules // nil for the classic rules
+	regions C.int  // extra region and chess rule flags passed to the C solver
+}
+
+// cRegions maps the extra regions libsudoku understands to their flags
+var cRegions = map[string]C.int{
+	RegionDiagonal: C.SUDOKU_REGION_DIAGONAL,
+	RegionWindoku:  C.SUDOKU_REGION_WINDOKU,
+}
+
+// forRules returns a libsudoku solver for r if r is the classic rules plus
+// extra regions and chess rules the C solver knows. The C solver only knows single 9x9 grids
+// with 3x3 boxes.
+func (LibSudokuSolver) forRules(r *rules) (Solver, bool) {
+	if len(r.constraints) > 0 || r.lay.regionMap != nil || r.lay.width != ClassicSize {
+		return nil, false
+	}
+	s := LibSudokuSolver{rules: r}
+	for _, name := range r.lay.extraRegions {
+		flag, ok := cRegions[name]
+		if !ok {
+			return nil, false
+		}
+		s.regions |= flag
+	}
+	if r.lay.antiKnight {
+		s.regions |= C.SUDOKU_RULE_ANTI_KNIGHT
+	}
+	if r.lay.antiKing {
+		s.regions |= C.SUDOKU_RULE_ANTI_KING
+	}
+	return s, true
+}
+
+// Name returns the backend name
+func (LibSudokuSolver) Name() string {
+	return "libsudoku"
+}
+
+// cSearchSlice is how many nodes the C search visits between context checks
+const cSearchSlice = 1 << 16
+
+// Solve fills grid in place with the first solution the C search finds
+func (s LibSudokuSolver) Solve(ctx context.Context, grid *PuzzleGrid, limits SearchLimits) (bool, error) {
+	limits.MaxSolutions = 1
+	search, err := s.search(ctx, *grid, limits)
+	if search.solutions == 0 {
+		return false, err
+	}
+
+	var cg cGrid
+	for cell, v := range search.solution {
+		cg[cell/ClassicSize][cell%ClassicSize] = v
+	}
+	*grid = fromCGrid(cg)
+	return true, nil
+}
+
+// CountSolutions counts solutions with the C search, up to limits.MaxSolutions
+func (s LibSudokuSolver) CountSolutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (int, error) {
+	search, err := s.search(ctx, grid, limits)
+	return int(search.solutions), err
+}
+
+// search runs the C search on grid until it finishes, finds
+// limits.MaxSolutions solutions or runs out of budget
+func (s LibSudokuSolver) search(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (*C.sudoku_search, error) {
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+
+	search := new(C.sudoku_search)
+	cg := toCGrid(grid)
+	C.sudoku_search_init(search, (*C.int)(unsafe.Pointer(&cg[0][0])), s.regions)
+	for !bool(search.finished) && limits.wantsMore(int(search.solutions)) {
+		slice := int64(cSearchSlice)
+		if limits.MaxNodes > 0 {
+			remaining := limits.MaxNodes - int64(search.nodes)
+			if remaining <= 0 {
+				return search, s.exceeded(search, ErrNodeBudget)
+			}
+			if remaining < slice {
+				slice = remaining
+			}
+		}
+		C.sudoku_search_run(search, C.int(limits.MaxSolutions), C.longlong(slice))
+		if err := ctx.Err(); err != nil && !bool(search.finished) {
+			return search, s.exceeded(search, err)
+		}
+	}
+	return search, nil
+}
+
+// exceeded reports a C search cut short
+func (LibSudokuSolver) exceeded(search *C.sudoku_search, cause error) error {
+	return &BudgetExceededError{Nodes: int64(search.nodes), Solutions: int(search.solutions), Cause: cause}
+}
+
+// Candidates is computed in Go since libsudoku does not expose candidates
+func (s LibSudokuSolver) Candidates(grid PuzzleGrid) CandidateGrid {
+	return NativeSolver{rules: s.rules}.Candidates(grid)
+}
+
+func toCGrid(grid PuzzleGrid) cGrid {
+	var cg cGrid
+	for row := range cg {
+		for col := range cg[row] {
+			cg[row][col] = C.int(grid[row][col])
+		}
+	}
+	return cg
+}
+
+func fromCGrid(cg cGrid) PuzzleGrid {
+	var grid PuzzleGrid
+	for row := range cg {
+		for col := range cg[row] {
+			grid[row][col] = int(cg[row][col])
+		}
+	}
+	return grid
+}
@@ -0,0 +1,126 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNodeBudget is the cause reported when a search visits too many nodes
+var ErrNodeBudget = errors.New("node budget exhausted")
+
+// SearchLimits bounds how much work a solve or solution count may do.
+// The zero value means no limits.
+type SearchLimits struct {
+	MaxSolutions int           // stop after finding this many solutions (0 = find all)
+	MaxNodes     int64         // give up after visiting this many search nodes (0 = unlimited)
+	Timeout      time.Duration // give up after this long (0 = no timeout)
+}
+
+// BudgetExceededError is returned when a search runs out of nodes or time,
+// or its context is cancelled, before it could finish
+type BudgetExceededError struct {
+	Nodes     int64 // search nodes visited before giving up
+	Solutions int   // solutions found before giving up
+	Cause     error // ErrNodeBudget or the context error
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("search budget exceeded after %d nodes and %d solutions: %v", e.Nodes, e.Solutions, e.Cause)
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return e.Cause
+}
+
+// Uniqueness classifies a grid by how many solutions it has
+type Uniqueness int
+
+const (
+	NoSolution Uniqueness = iota
+	UniqueSolution
+	MultipleSolutions
+)
+
+func (u Uniqueness) String() string {
+	switch u {
+	case NoSolution:
+		return "none"
+	case UniqueSolution:
+		return "unique"
+	default:
+		return "multiple"
+	}
+}
+
+// contextCheckInterval is how many nodes are visited between context checks
+const contextCheckInterval = 1024
+
+// budget tracks the work done by a single search
+type budget struct {
+	ctx      context.Context
+	maxNodes int64
+	nodes    int64
+	err      error
+}
+
+// newBudget applies the node and time limits to ctx. The returned cancel
+// function must be called once the search is done.
+func newBudget(ctx context.Context, limits SearchLimits) (*budget, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+	return &budget{ctx: ctx, maxNodes: limits.MaxNodes}, cancel
+}
+
+// spend accounts for one search node and reports whether the search may go on
+func (bg *budget) spend() bool {
+	if bg.err != nil {
+		return false
+	}
+
+	bg.nodes++
+	if bg.maxNodes > 0 && bg.nodes > bg.maxNodes {
+		bg.err = ErrNodeBudget
+		return false
+	}
+	if bg.nodes%contextCheckInterval == 0 {
+		if err := bg.ctx.Err(); err != nil {
+			bg.err = err
+			return false
+		}
+	}
+	return true
+}
+
+// result turns the outcome of a search into an error, if it was cut short
+func (bg *budget) result(solutions int) error {
+	if bg.err == nil {
+		return nil
+	}
+	return &BudgetExceededError{Nodes: bg.nodes, Solutions: solutions, Cause: bg.err}
+}
+
+// wantsMore reports whether a search that has found count solutions should continue
+func (limits SearchLimits) wantsMore(count int) bool {
+	return limits.MaxSolutions <= 0 || count < limits.MaxSolutions
+}
+
+// CountSolutions counts the solutions of grid with the active backend
+func CountSolutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (int, error) {
+	return ActiveSolver().CountSolutions(ctx, grid, limits)
+}
+
+// CheckUniqueness reports whether grid has no, one or several solutions. It
+// stops as soon as a second solution is found, so it is cheap even for grids
+// with very many solutions.
+func CheckUniqueness(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (Uniqueness, error) {
+	limits.MaxSolutions = 2
+	count, err := CountSolutions(ctx, grid, limits)
+	if err != nil {
+		return NoSolution, err
+	}
+	return Uniqueness(count), nil
+}
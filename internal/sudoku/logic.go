@@ -0,0 +1,865 @@
+package sudoku
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// Technique names a human solving technique
+type Technique string
+
+// Techniques understood by the logical solver, roughly from easiest to hardest
+const (
+	TechniqueHiddenSingle     Technique = "Hidden Single"
+	TechniqueNakedSingle      Technique = "Naked Single"
+	TechniquePointing         Technique = "Pointing Pair"
+	TechniqueBoxLineReduction Technique = "Box/Line Reduction"
+	TechniqueNakedPair        Technique = "Naked Pair"
+	TechniqueXWing            Technique = "X-Wing"
+	TechniqueHiddenPair       Technique = "Hidden Pair"
+	TechniqueNakedTriple      Technique = "Naked Triple"
+	TechniqueSwordfish        Technique = "Swordfish"
+	TechniqueHiddenTriple     Technique = "Hidden Triple"
+	TechniqueXYWing           Technique = "XY-Wing"
+	TechniqueXYZWing          Technique = "XYZ-Wing"
+	TechniqueNakedQuad        Technique = "Naked Quad"
+	TechniqueJellyfish        Technique = "Jellyfish"
+	TechniqueHiddenQuad       Technique = "Hidden Quad"
+	TechniqueCageSum          Technique = "Cage Sum"
+	TechniqueConstraint       Technique = "Variant Constraint"
+)
+
+// Placement puts a digit in a cell
+type Placement struct {
+	Cell  string `json:"cell"` // Position key (01-81 on a 9x9 grid)
+	Value int    `json:"value"`
+}
+
+// Elimination removes candidates from a cell
+type Elimination struct {
+	Cell   string `json:"cell"` // Position key (01-81 on a 9x9 grid)
+	Values []int  `json:"values"`
+}
+
+// Step is a single logical deduction
+type Step struct {
+	Technique    Technique     `json:"technique"`
+	Description  string        `json:"description"`
+	Units        []string      `json:"units,omitempty"`  // Regions the deduction is made in
+	Cells        []string      `json:"cells"`            // Cells the deduction relies on
+	Digits       []int         `json:"digits,omitempty"` // Digits the deduction is about
+	Placements   []Placement   `json:"placements,omitempty"`
+	Eliminations []Elimination `json:"eliminations,omitempty"`
+}
+
+// LogicResult is the outcome of solving a grid with logic alone
+type LogicResult struct {
+	Steps  []Step     `json:"steps"`
+	Solved bool       `json:"solved"`
+	Stuck  bool       `json:"stuck"`  // No technique applies but the grid isn't solved
+	Broken bool       `json:"broken"` // The grid contradicts itself
+	Grid   PuzzleGrid `json:"-"`      // State after all steps were applied
+}
+
+// deduction is the internal form of a Step, working on cell indexes
+type deduction struct {
+	technique  Technique
+	units      []int
+	cells      []int
+	digits     uint32
+	placements [][2]int // cell, digit
+	elims      map[int]uint32
+	describe   func() string
+}
+
+// logicState holds the values and pencil marks of a grid being solved by logic
+type logicState struct {
+	lay    *layout
+	rules  *rules
+	values []int
+	cands  []uint32 // 0 for filled cells
+}
+
+// logicTechnique is one technique the logical solver can try
+type logicTechnique struct {
+	name  Technique
+	apply func(st *logicState) *deduction
+}
+
+// logicTechniques lists every technique in the order the solver tries them.
+// After any successful step the solver starts over from the top, so the
+// trace always uses the simplest technique available.
+var logicTechniques = []logicTechnique{
+	{TechniqueHiddenSingle, (*logicState).hiddenSingle},
+	{TechniqueNakedSingle, (*logicState).nakedSingle},
+	{TechniqueCageSum, func(st *logicState) *deduction { return st.constraintRule(TechniqueCageSum) }},
+	{TechniqueConstraint, func(st *logicState) *deduction { return st.constraintRule(TechniqueConstraint) }},
+	{TechniquePointing, func(st *logicState) *deduction { return st.lockedCandidates(true) }},
+	{TechniqueBoxLineReduction, func(st *logicState) *deduction { return st.lockedCandidates(false) }},
+	{TechniqueNakedPair, func(st *logicState) *deduction { return st.nakedSubset(2) }},
+	{TechniqueXWing, func(st *logicState) *deduction { return st.fish(2) }},
+	{TechniqueHiddenPair, func(st *logicState) *deduction { return st.hiddenSubset(2) }},
+	{TechniqueNakedTriple, func(st *logicState) *deduction { return st.nakedSubset(3) }},
+	{TechniqueSwordfish, func(st *logicState) *deduction { return st.fish(3) }},
+	{TechniqueHiddenTriple, func(st *logicState) *deduction { return st.hiddenSubset(3) }},
+	{TechniqueXYWing, (*logicState).xyWing},
+	{TechniqueXYZWing, (*logicState).xyzWing},
+	{TechniqueNakedQuad, func(st *logicState) *deduction { return st.nakedSubset(4) }},
+	{TechniqueJellyfish, func(st *logicState) *deduction { return st.fish(4) }},
+	{TechniqueHiddenQuad, func(st *logicState) *deduction { return st.hiddenSubset(4) }},
+}
+
+// ParseTechnique looks up a technique by name, ignoring case, spaces and
+// punctuation, so "xy-wing" and "XYWing" both name TechniqueXYWing
+func ParseTechnique(name string) (Technique, error) {
+	if name == "" {
+		return "", nil
+	}
+	key := techniqueKey(name)
+	for _, technique := range logicTechniques {
+		if techniqueKey(string(technique.name)) == key {
+			return technique.name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown technique %q", name)
+}
+
+// techniqueKey normalises a technique name for lookup
+func techniqueKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, name)
+}
+
+// SolveLogically applies human solving techniques to grid until it is solved
+// or no technique makes progress, and returns the ordered list of steps. It
+// stops early, leaving the grid unsolved, if ctx is cancelled.
+func SolveLogically(ctx context.Context, grid PuzzleGrid) LogicResult {
+	return solveLogically(ctx, classicRules, grid)
+}
+
+// solveLogically is SolveLogically under the given rules
+func solveLogically(ctx context.Context, r *rules, grid PuzzleGrid) LogicResult {
+	st := newLogicState(r, grid)
+	result := LogicResult{Steps: []Step{}}
+
+	for {
+		if ctx.Err() != nil {
+			utils.Log(utils.LogLevelDebug, "Logical solve cut short: %v", ctx.Err())
+			break
+		}
+		if st.broken() {
+			result.Broken = true
+			break
+		}
+		if st.solved() {
+			result.Solved = true
+			break
+		}
+
+		d := st.next()
+		if d == nil {
+			result.Stuck = true
+			break
+		}
+		result.Steps = append(result.Steps, st.step(d))
+		st.apply(d)
+	}
+
+	result.Grid = st.grid()
+	utils.Log(utils.LogLevelDebug, "Logical solve finished after %d steps (solved=%v, stuck=%v, broken=%v)",
+		len(result.Steps), result.Solved, result.Stuck, result.Broken)
+	return result
+}
+
+func newLogicState(r *rules, grid PuzzleGrid) *logicState {
+	b := newBoardFor(r, grid)
+	st := &logicState{lay: b.lay, rules: r, values: b.cells, cands: make([]uint32, len(b.cells))}
+	for cell, v := range st.values {
+		if v == 0 {
+			st.cands[cell] = b.candidates(cell)
+		}
+	}
+	return st
+}
+
+func (st *logicState) grid() PuzzleGrid {
+	b := board{lay: st.lay, rules: st.rules, cells: st.values}
+	return b.grid()
+}
+
+func (st *logicState) solved() bool {
+	for _, v := range st.values {
+		if v == 0 {
+			return false
+		}
+	}
+	return (&board{lay: st.lay, rules: st.rules, cells: st.values}).consistent()
+}
+
+// broken reports whether an empty cell has run out of candidates, a unit
+// can no longer place one of its digits, or the filled cells clash
+func (st *logicState) broken() bool {
+	for cell, v := range st.values {
+		if v == 0 && st.cands[cell] == 0 {
+			return true
+		}
+	}
+	for _, unit := range st.lay.units {
+		var seen uint32
+		for _, cell := range unit {
+			if v := st.values[cell]; v != 0 {
+				if seen&(1<<uint(v)) != 0 {
+					return true
+				}
+				seen |= 1 << uint(v)
+			} else {
+				seen |= st.cands[cell]
+			}
+		}
+		if seen&st.lay.full != st.lay.full {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the simplest deduction available, or nil if none applies
+func (st *logicState) next() *deduction {
+	for _, t := range logicTechniques {
+		if d := t.apply(st); d != nil {
+			d.technique = t.name
+			return d
+		}
+	}
+	return nil
+}
+
+// apply places and eliminates the digits of a deduction
+func (st *logicState) apply(d *deduction) {
+	for _, p := range d.placements {
+		st.place(p[0], p[1])
+	}
+	for cell, mask := range d.elims {
+		st.cands[cell] &^= mask
+	}
+}
+
+func (st *logicState) place(cell, digit int) {
+	st.values[cell] = digit
+	st.cands[cell] = 0
+	for _, peer := range st.lay.peers[cell] {
+		st.cands[peer] &^= 1 << uint(digit)
+	}
+}
+
+// step converts a deduction into its exported form
+func (st *logicState) step(d *deduction) Step {
+	s := Step{
+		Technique:   d.technique,
+		Description: d.describe(),
+		Cells:       make([]string, 0, len(d.cells)),
+		Digits:      maskDigits(d.digits),
+	}
+	for _, u := range d.units {
+		s.Units = append(s.Units, st.lay.unitNames[u])
+	}
+	for _, cell := range d.cells {
+		s.Cells = append(s.Cells, st.lay.key(cell))
+	}
+	for _, p := range d.placements {
+		s.Placements = append(s.Placements, Placement{Cell: st.lay.key(p[0]), Value: p[1]})
+	}
+
+	elimCells := make([]int, 0, len(d.elims))
+	for cell := range d.elims {
+		elimCells = append(elimCells, cell)
+	}
+	sort.Ints(elimCells)
+	for _, cell := range elimCells {
+		s.Eliminations = append(s.Eliminations, Elimination{Cell: st.lay.key(cell), Values: maskDigits(d.elims[cell])})
+	}
+	return s
+}
+
+// eliminate records the removal of mask from cell if it removes anything
+func (d *deduction) eliminate(st *logicState, cell int, mask uint32) {
+	if mask &= st.cands[cell]; mask == 0 {
+		return
+	}
+	if d.elims == nil {
+		d.elims = make(map[int]uint32)
+	}
+	d.elims[cell] |= mask
+}
+
+func (st *logicState) names(cells []int) string {
+	names := make([]string, len(cells))
+	for i, cell := range cells {
+		names[i] = st.lay.cellName(cell)
+	}
+	return strings.Join(names, ", ")
+}
+
+func digitList(mask uint32) string {
+	digits := maskDigits(mask)
+	parts := make([]string, len(digits))
+	for i, d := range digits {
+		parts[i] = fmt.Sprint(d)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (st *logicState) hiddenSingle() *deduction {
+	for u, unit := range st.lay.units {
+		var placed, once, twice uint32
+		for _, cell := range unit {
+			if v := st.values[cell]; v != 0 {
+				placed |= 1 << uint(v)
+				continue
+			}
+			twice |= once & st.cands[cell]
+			once |= st.cands[cell]
+		}
+
+		single := once &^ twice &^ placed
+		if single == 0 {
+			continue
+		}
+		digit := bits.TrailingZeros32(single)
+		for _, cell := range unit {
+			if st.values[cell] == 0 && st.cands[cell]&(1<<uint(digit)) != 0 {
+				u, cell := u, cell
+				return &deduction{
+					units:      []int{u},
+					cells:      []int{cell},
+					digits:     1 << uint(digit),
+					placements: [][2]int{{cell, digit}},
+					describe: func() string {
+						return fmt.Sprintf("%d can only go in %s within %s", digit, st.lay.cellName(cell), st.lay.unitNames[u])
+					},
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (st *logicState) nakedSingle() *deduction {
+	for cell, mask := range st.cands {
+		if st.values[cell] != 0 || bits.OnesCount32(mask) != 1 {
+			continue
+		}
+		cell, digit := cell, bits.TrailingZeros32(mask)
+		return &deduction{
+			cells:      []int{cell},
+			digits:     mask,
+			placements: [][2]int{{cell, digit}},
+			describe: func() string {
+				return fmt.Sprintf("%s can only be %d", st.lay.cellName(cell), digit)
+			},
+		}
+	}
+	return nil
+}
+
+// constraintRule removes candidates that can't be part of any way to fill
+// the cells of a constraint, e.g. digits that fit no combination of a cage.
+// Only constraints applied by the given technique are looked at.
+func (st *logicState) constraintRule(technique Technique) *deduction {
+	for _, c := range st.rules.constraints {
+		if c.Technique() != technique {
+			continue
+		}
+
+		var open []int
+		for _, cell := range c.Cells() {
+			if st.values[cell] == 0 {
+				open = append(open, cell)
+			}
+		}
+
+		d := &deduction{cells: c.Cells()}
+		for _, cell := range open {
+			for _, digit := range maskDigits(st.cands[cell]) {
+				if !st.constraintSupports(c, open, cell, digit) {
+					d.eliminate(st, cell, 1<<uint(digit))
+					d.digits |= 1 << uint(digit)
+				}
+			}
+		}
+		if d.elims == nil {
+			continue
+		}
+		c := c
+		d.describe = func() string {
+			return fmt.Sprintf("%s rules out %s", c.Describe(st.lay.cellName), st.elimList(d.elims))
+		}
+		return d
+	}
+	return nil
+}
+
+// maxSupportNodes bounds the search for a way to fill a constraint's cells.
+// A digit whose search runs out is kept, so no wrong elimination is made.
+const maxSupportNodes = 20000
+
+// constraintSupports reports whether digit in cell is part of some way to
+// fill the open cells of c from their candidates
+func (st *logicState) constraintSupports(c Constraint, open []int, cell, digit int) bool {
+	st.values[cell] = digit
+	defer func() { st.values[cell] = 0 }()
+
+	nodes := 0
+	var fill func(i int) bool
+	fill = func(i int) bool {
+		if nodes++; nodes > maxSupportNodes {
+			return true
+		}
+		if i == len(open) {
+			return true
+		}
+		next := open[i]
+		if next == cell {
+			return fill(i + 1)
+		}
+
+		mask := st.cands[next] & c.Allowed(st.values, next)
+		for _, other := range open[:i] {
+			if v := st.values[other]; v != 0 && intsContain(st.lay.peers[next], other) {
+				mask &^= 1 << uint(v)
+			}
+		}
+		if intsContain(st.lay.peers[next], cell) {
+			mask &^= 1 << uint(digit)
+		}
+		for ; mask != 0; mask &= mask - 1 {
+			st.values[next] = bits.TrailingZeros32(mask)
+			ok := fill(i + 1)
+			st.values[next] = 0
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The digit itself must fit with the cells already filled
+	if c.Allowed(st.values, cell)&(1<<uint(digit)) == 0 {
+		return false
+	}
+	return fill(0)
+}
+
+// elimList describes eliminations, e.g. "5 from r1c1, 7/9 from r1c2"
+func (st *logicState) elimList(elims map[int]uint32) string {
+	cells := make([]int, 0, len(elims))
+	for cell := range elims {
+		cells = append(cells, cell)
+	}
+	sort.Ints(cells)
+
+	parts := make([]string, len(cells))
+	for i, cell := range cells {
+		parts[i] = fmt.Sprintf("%s from %s", digitList(elims[cell]), st.lay.cellName(cell))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// lockedCandidates finds a digit whose candidates in one unit all lie in a
+// second unit, so it can be removed from the rest of the second unit. With
+// pointing set the first unit is a box (pointing pairs and triples);
+// otherwise it is a row or column (box/line reduction).
+func (st *logicState) lockedCandidates(pointing bool) *deduction {
+	for u, unit := range st.lay.units {
+		if (st.lay.unitKinds[u] == unitBox) != pointing {
+			continue
+		}
+
+		for digit := 1; digit <= st.lay.size; digit++ {
+			bit := uint32(1) << uint(digit)
+			var cells []int
+			for _, cell := range unit {
+				if st.values[cell] == 0 && st.cands[cell]&bit != 0 {
+					cells = append(cells, cell)
+				}
+			}
+			if len(cells) < 2 {
+				continue
+			}
+
+			for _, other := range st.lay.cellUnits[cells[0]] {
+				if other == u || (st.lay.unitKinds[other] == unitBox) == pointing || !unitHasAll(st.lay, other, cells) {
+					continue
+				}
+
+				d := &deduction{units: []int{u, other}, cells: cells, digits: bit}
+				for _, cell := range st.lay.units[other] {
+					if !intsContain(unit, cell) {
+						d.eliminate(st, cell, bit)
+					}
+				}
+				if d.elims == nil {
+					continue
+				}
+				u, other, digit := u, other, digit
+				d.describe = func() string {
+					return fmt.Sprintf("in %s, %d is confined to %s, so it can be removed from the rest of %s",
+						st.lay.unitNames[u], digit, st.names(cells), st.lay.unitNames[other])
+				}
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// nakedSubset finds size cells in a unit whose candidates together contain
+// exactly size digits; those digits can be removed from the rest of the unit
+func (st *logicState) nakedSubset(size int) *deduction {
+	for u, unit := range st.lay.units {
+		var open []int
+		for _, cell := range unit {
+			if n := bits.OnesCount32(st.cands[cell]); st.values[cell] == 0 && n >= 2 && n <= size {
+				open = append(open, cell)
+			}
+		}
+
+		var found *deduction
+		combinations(len(open), size, func(idx []int) bool {
+			var union uint32
+			cells := make([]int, size)
+			for i, j := range idx {
+				cells[i] = open[j]
+				union |= st.cands[open[j]]
+			}
+			if bits.OnesCount32(union) != size {
+				return true
+			}
+
+			d := &deduction{units: []int{u}, cells: cells, digits: union}
+			for _, cell := range unit {
+				if st.values[cell] == 0 && !intsContain(cells, cell) {
+					d.eliminate(st, cell, union)
+				}
+			}
+			if d.elims == nil {
+				return true
+			}
+			d.describe = func() string {
+				return fmt.Sprintf("%s hold only %s in %s, so those digits can be removed from the rest of the %s",
+					st.names(cells), digitList(union), st.lay.unitNames[u], st.lay.unitNames[u])
+			}
+			found = d
+			return false
+		})
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// hiddenSubset finds size digits confined to the same size cells of a unit;
+// every other candidate can be removed from those cells
+func (st *logicState) hiddenSubset(size int) *deduction {
+	for u, unit := range st.lay.units {
+		var placed uint32
+		for _, cell := range unit {
+			if v := st.values[cell]; v != 0 {
+				placed |= 1 << uint(v)
+			}
+		}
+
+		var digits []int
+		for _, digit := range maskDigits(st.lay.full &^ placed) {
+			count := 0
+			for _, cell := range unit {
+				if st.values[cell] == 0 && st.cands[cell]&(1<<uint(digit)) != 0 {
+					count++
+				}
+			}
+			if count >= 2 && count <= size {
+				digits = append(digits, digit)
+			}
+		}
+
+		var found *deduction
+		combinations(len(digits), size, func(idx []int) bool {
+			var subset uint32
+			for _, j := range idx {
+				subset |= 1 << uint(digits[j])
+			}
+
+			var cells []int
+			for _, cell := range unit {
+				if st.values[cell] == 0 && st.cands[cell]&subset != 0 {
+					cells = append(cells, cell)
+				}
+			}
+			if len(cells) != size {
+				return true
+			}
+
+			d := &deduction{units: []int{u}, cells: cells, digits: subset}
+			for _, cell := range cells {
+				d.eliminate(st, cell, ^subset)
+			}
+			if d.elims == nil {
+				return true
+			}
+			d.describe = func() string {
+				return fmt.Sprintf("%s can only go in %s within %s, so other candidates can be removed from those cells",
+					digitList(subset), st.names(cells), st.lay.unitNames[u])
+			}
+			found = d
+			return false
+		})
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// fish finds size rows (or columns) in which a digit is confined to the same
+// size columns (or rows); the digit can then be removed from the rest of those
+// columns (or rows). Size 2 is an X-Wing, 3 a Swordfish and 4 a Jellyfish.
+func (st *logicState) fish(size int) *deduction {
+	for _, kinds := range [][2]unitKind{{unitRow, unitColumn}, {unitColumn, unitRow}} {
+		baseKind, coverKind := kinds[0], kinds[1]
+
+		for digit := 1; digit <= st.lay.size; digit++ {
+			bit := uint32(1) << uint(digit)
+
+			var bases []int
+			baseCells := map[int][]int{}
+			for u, unit := range st.lay.units {
+				if st.lay.unitKinds[u] != baseKind {
+					continue
+				}
+				var cells []int
+				for _, cell := range unit {
+					if st.values[cell] == 0 && st.cands[cell]&bit != 0 {
+						cells = append(cells, cell)
+					}
+				}
+				if len(cells) >= 2 && len(cells) <= size {
+					bases = append(bases, u)
+					baseCells[u] = cells
+				}
+			}
+
+			var found *deduction
+			combinations(len(bases), size, func(idx []int) bool {
+				var baseUnits, cells, covers []int
+				for _, j := range idx {
+					// Rows of overlapping grids can share cells, and
+					// only disjoint bases need the digit in as many covers
+					for _, cell := range baseCells[bases[j]] {
+						if intsContain(cells, cell) {
+							return true
+						}
+					}
+					baseUnits = append(baseUnits, bases[j])
+					cells = append(cells, baseCells[bases[j]]...)
+				}
+				for _, cell := range cells {
+					cover := unitOfKind(st.lay, cell, coverKind)
+					if !intsContain(covers, cover) {
+						covers = append(covers, cover)
+					}
+				}
+				if len(covers) != size {
+					return true
+				}
+
+				d := &deduction{units: append(append([]int{}, baseUnits...), covers...), cells: cells, digits: bit}
+				for _, cover := range covers {
+					for _, cell := range st.lay.units[cover] {
+						if !intsContain(cells, cell) {
+							d.eliminate(st, cell, bit)
+						}
+					}
+				}
+				if d.elims == nil {
+					return true
+				}
+				d.describe = func() string {
+					return fmt.Sprintf("in %s, %d is confined to %s, so it can be removed from the rest of %s",
+						st.unitList(baseUnits), digit, st.names(cells), st.unitList(covers))
+				}
+				found = d
+				return false
+			})
+			if found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// xyWing finds a pivot cell with candidates xy that sees two pincers with
+// candidates xz and yz; any cell seeing both pincers can't be z
+func (st *logicState) xyWing() *deduction {
+	for pivot, pivotMask := range st.cands {
+		if st.values[pivot] != 0 || bits.OnesCount32(pivotMask) != 2 {
+			continue
+		}
+
+		var pincers []int
+		for _, peer := range st.lay.peers[pivot] {
+			mask := st.cands[peer]
+			if st.values[peer] == 0 && bits.OnesCount32(mask) == 2 && bits.OnesCount32(mask&pivotMask) == 1 {
+				pincers = append(pincers, peer)
+			}
+		}
+
+		for i, a := range pincers {
+			for _, b := range pincers[i+1:] {
+				z := st.cands[a] &^ pivotMask
+				if st.cands[b]&^pivotMask != z || st.cands[a]&st.cands[b]&pivotMask != 0 {
+					continue
+				}
+
+				d := &deduction{cells: []int{pivot, a, b}, digits: z}
+				for _, cell := range st.lay.peers[a] {
+					if cell != b && cell != pivot && intsContain(st.lay.peers[b], cell) {
+						d.eliminate(st, cell, z)
+					}
+				}
+				if d.elims == nil {
+					continue
+				}
+				pivot, a, b := pivot, a, b
+				d.describe = func() string {
+					return fmt.Sprintf("pivot %s with pincers %s and %s: one pincer must be %s, so cells seeing both can't be %s",
+						st.lay.cellName(pivot), st.lay.cellName(a), st.lay.cellName(b), digitList(z), digitList(z))
+				}
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// xyzWing finds a pivot with candidates xyz that sees pincers with xz and yz;
+// any cell seeing the pivot and both pincers can't be z
+func (st *logicState) xyzWing() *deduction {
+	for pivot, pivotMask := range st.cands {
+		if st.values[pivot] != 0 || bits.OnesCount32(pivotMask) != 3 {
+			continue
+		}
+
+		var pincers []int
+		for _, peer := range st.lay.peers[pivot] {
+			mask := st.cands[peer]
+			if st.values[peer] == 0 && bits.OnesCount32(mask) == 2 && mask&^pivotMask == 0 {
+				pincers = append(pincers, peer)
+			}
+		}
+
+		for i, a := range pincers {
+			for _, b := range pincers[i+1:] {
+				z := st.cands[a] & st.cands[b]
+				if bits.OnesCount32(z) != 1 || st.cands[a]|st.cands[b] != pivotMask {
+					continue
+				}
+
+				d := &deduction{cells: []int{pivot, a, b}, digits: z}
+				for _, cell := range st.lay.peers[pivot] {
+					if cell != a && cell != b && intsContain(st.lay.peers[a], cell) && intsContain(st.lay.peers[b], cell) {
+						d.eliminate(st, cell, z)
+					}
+				}
+				if d.elims == nil {
+					continue
+				}
+				pivot, a, b := pivot, a, b
+				d.describe = func() string {
+					return fmt.Sprintf("pivot %s with pincers %s and %s: one of them must be %s, so cells seeing all three can't be %s",
+						st.lay.cellName(pivot), st.lay.cellName(a), st.lay.cellName(b), digitList(z), digitList(z))
+				}
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+func (st *logicState) unitList(units []int) string {
+	names := make([]string, len(units))
+	for i, u := range units {
+		names[i] = st.lay.unitNames[u]
+	}
+	return strings.Join(names, ", ")
+}
+
+// unitHasAll reports whether unit u contains every one of cells
+func unitHasAll(lay *layout, u int, cells []int) bool {
+	for _, cell := range cells {
+		if !intsContain(lay.units[u], cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// unitOfKind returns the first unit of the given kind containing cell
+func unitOfKind(lay *layout, cell int, kind unitKind) int {
+	for _, u := range lay.cellUnits[cell] {
+		if lay.unitKinds[u] == kind {
+			return u
+		}
+	}
+	return -1
+}
+
+func intsContain(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// combinations calls fn with every k-element combination of 0..n-1 in
+// lexicographic order until fn returns false
+func combinations(n, k int, fn func([]int) bool) {
+	if k > n || k <= 0 {
+		return
+	}
+	idx := make([]int, k)
+	for i := range idx {
+		idx[i] = i
+	}
+	for {
+		if !fn(idx) {
+			return
+		}
+		i := k - 1
+		for i >= 0 && idx[i] == n-k+i {
+			i--
+		}
+		if i < 0 {
+			return
+		}
+		idx[i]++
+		for j := i + 1; j < k; j++ {
+			idx[j] = idx[j-1] + 1
+		}
+	}
+}
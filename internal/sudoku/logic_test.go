@@ -0,0 +1,151 @@
+package sudoku
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// checkSteps fails the test if a step places a digit other than the
+// solution's or eliminates the solution's digit from a cell
+func checkSteps(t *testing.T, lay *layout, steps []Step, solution PuzzleGrid) {
+	t.Helper()
+	for i, step := range steps {
+		for _, p := range step.Placements {
+			row, col := lay.rowCol(lay.cellOf(p.Cell))
+			if solution[row][col] != p.Value {
+				t.Errorf("step %d (%s) places %d in %s, solution has %d", i+1, step.Technique, p.Value, p.Cell, solution[row][col])
+			}
+		}
+		for _, e := range step.Eliminations {
+			row, col := lay.rowCol(lay.cellOf(e.Cell))
+			if intsContain(e.Values, solution[row][col]) {
+				t.Errorf("step %d (%s) eliminates %v from %s, solution has %d", i+1, step.Technique, e.Values, e.Cell, solution[row][col])
+			}
+		}
+	}
+}
+
+func TestSolveLogicallyTechniques(t *testing.T) {
+	// Each grid needs its technique and nothing harder
+	tests := []struct {
+		technique Technique
+		grid      string
+	}{
+		{TechniqueHiddenSingle, classicPuzzle},
+		{TechniqueNakedSingle, "790000201000040095002080000006000002000508300803000150600800973000630000005974620"},
+		{TechniquePointing, "790000201000040005002000000006000002000508300800000100600800973000600000005974020"},
+		{TechniqueBoxLineReduction, "003092000060073091900010600605420000000758000800106500346080009700005000150367280"},
+		{TechniqueNakedPair, "010607000062000000005004000000000698650000000200800017009076300070090802000500900"},
+		{TechniqueXWing, "000000000730060400000402001002158607600204180000000030080300900906020803010006000"},
+		{TechniqueHiddenPair, "038090010000000000012000007050001300000000075480000006600940020000007600000025000"},
+		{TechniqueNakedTriple, "090500087685027090070080000936415728842793156157268040368050072014072800729800010"},
+		{TechniqueSwordfish, "100370040405600738607804105000058070750460980084000010900040007506080000870906450"},
+		{TechniqueHiddenTriple, "090140000050007080007280000026508000015000006000006090030000904602000100000451300"},
+		{TechniqueXYWing, "000800300000000009930265018200090680060000005405001002004038050000007800350000090"},
+		{TechniqueXYZWing, "081020950204000000600580240002000300800050029000742008968000000475018602003065800"},
+		{TechniqueNakedQuad, "009607005180450600500123008000561009001032050000748061243070006000204007718396042"},
+		{TechniqueJellyfish, "000800001040050090200039006000000000068000005020008003000003080000604300050012400"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.technique), func(t *testing.T) {
+			grid := parseGrid(t, tt.grid, 9)
+			solution := grid
+			if solved, err := (NativeSolver{}).Solve(context.Background(), &solution, SearchLimits{}); !solved {
+				t.Fatalf("grid has no solution (%v)", err)
+			}
+
+			result := SolveLogically(context.Background(), grid)
+			if !result.Solved || result.Grid != solution {
+				t.Fatalf("logical solve: solved %v, stuck %v, broken %v", result.Solved, result.Stuck, result.Broken)
+			}
+			checkSteps(t, classicLayout, result.Steps, solution)
+
+			rating := rateTrace(result)
+			if rating.Hardest != tt.technique {
+				t.Errorf("hardest technique %q, want %q", rating.Hardest, tt.technique)
+			}
+		})
+	}
+}
+
+func TestSolveLogicallyConstraints(t *testing.T) {
+	solution := parseGrid(t, classicSolution, 9)
+
+	// White dots between every consecutive pair of neighbours in a row, and
+	// random killer cages, all true to the solution
+	var dots []models.Constraint
+	for row := 0; row < 9; row++ {
+		for col := 0; col < 8; col++ {
+			if diff := solution[row][col] - solution[row][col+1]; diff == 1 || diff == -1 {
+				cell := row*9 + col
+				dots = append(dots, models.Constraint{Type: ConstraintKropkiWhite, Cells: []string{cellKey(cell), cellKey(cell + 1)}})
+			}
+		}
+	}
+	kropki, err := rulesFor(models.Puzzle{Constraints: dots})
+	if err != nil {
+		t.Fatalf("rulesFor: %v", err)
+	}
+	killer := classicRules
+	for _, c := range makeCages(solution, rand.New(rand.NewSource(1))) {
+		killer = killer.withConstraints(c)
+	}
+
+	tests := []struct {
+		name      string
+		rules     *rules
+		grid      string
+		technique Technique
+	}{
+		{"kropki", kropki, strings.Repeat("0", 27) + classicPuzzle[27:], TechniqueConstraint},
+		{"killer", killer, strings.Repeat("0", 81), TechniqueCageSum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := solveLogically(context.Background(), tt.rules, parseGrid(t, tt.grid, 9))
+			used := false
+			for _, step := range result.Steps {
+				used = used || step.Technique == tt.technique
+			}
+			if !used {
+				t.Errorf("none of %d steps uses %q", len(result.Steps), tt.technique)
+			}
+			checkSteps(t, tt.rules.lay, result.Steps, solution)
+		})
+	}
+}
+
+func TestSolveLogicallyStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := SolveLogically(ctx, parseGrid(t, classicPuzzle, 9))
+	if result.Solved || len(result.Steps) != 0 {
+		t.Errorf("cancelled solve made %d steps, solved %v", len(result.Steps), result.Solved)
+	}
+}
+
+func TestParseTechnique(t *testing.T) {
+	tests := []struct {
+		name string
+		want Technique
+		ok   bool
+	}{
+		{"", "", true},
+		{"xy-wing", TechniqueXYWing, true},
+		{"XYWing", TechniqueXYWing, true},
+		{"hidden single", TechniqueHiddenSingle, true},
+		{"box/line reduction", TechniqueBoxLineReduction, true},
+		{"cage sum", TechniqueCageSum, true},
+		{"fishing", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTechnique(tt.name)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseTechnique(%q) = %q, %v", tt.name, got, err)
+		}
+	}
+}
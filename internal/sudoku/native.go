@@ -0,0 +1,367 @@
+package sudoku
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+)
+
+// unitKind tells rows, columns and boxes apart for techniques that care
+type unitKind int
+
+const (
+	unitRow unitKind = iota
+	unitColumn
+	unitBox
+	unitExtra // Variant regions such as diagonals and windows
+)
+
+// layout describes the cells of a board and which of them constrain each other
+type layout struct {
+	size  int     // number of digits, and of rows and columns of a single grid
+	width int     // rows and columns of the board the cells sit on
+	full  uint32  // bitmask with a bit set for every digit 1..size
+	units [][]int // rows, columns and boxes as lists of cell indexes
+	peers [][]int // for each cell, every other cell sharing a unit with it
+
+	cellUnits [][]int    // for each cell, the indexes of the units containing it
+	unitKinds []unitKind // kind of each unit
+	unitNames []string   // human readable name of each unit, e.g. "row 3"
+
+	regionMap    []int    // jigsaw region (1..size) of each cell, nil for rectangular boxes
+	extraRegions []string // variant regions added on top of rows, columns and boxes
+
+	positions  []int // board position (row*width+col) of each cell, nil when the cells fill the board
+	boardCells []int // cell at each board position, -1 where there is none; nil with positions
+
+	antiKnight bool // cells a knight's move apart are peers
+	antiKing   bool // cells a king's move apart are peers
+}
+
+// classicLayout is the standard 9x9 board with 3x3 boxes
+var classicLayout = newLayout(9, 3, 3)
+
+// newLayout builds the units and peer lists for a size x size board
+func newLayout(size, boxRows, boxCols int) *layout {
+	lay := newLineLayout(size)
+	for boxRow := 0; boxRow < size; boxRow += boxRows {
+		for boxCol := 0; boxCol < size; boxCol += boxCols {
+			unit := make([]int, 0, size)
+			for row := boxRow; row < boxRow+boxRows; row++ {
+				for col := boxCol; col < boxCol+boxCols; col++ {
+					unit = append(unit, row*size+col)
+				}
+			}
+			lay.addUnit(unitBox, fmt.Sprintf("box %d", len(lay.units)-2*size+1), unit)
+		}
+	}
+
+	lay.index()
+	return lay
+}
+
+// newLineLayout starts a size x size layout with its rows and columns. The
+// caller adds the boxes and indexes it.
+func newLineLayout(size int) *layout {
+	lay := &layout{
+		size:  size,
+		width: size,
+		full:  uint32(1)<<uint(size+1) - 2,
+	}
+
+	for row := 0; row < size; row++ {
+		unit := make([]int, 0, size)
+		for col := 0; col < size; col++ {
+			unit = append(unit, row*size+col)
+		}
+		lay.addUnit(unitRow, fmt.Sprintf("row %d", row+1), unit)
+	}
+	for col := 0; col < size; col++ {
+		unit := make([]int, 0, size)
+		for row := 0; row < size; row++ {
+			unit = append(unit, row*size+col)
+		}
+		lay.addUnit(unitColumn, fmt.Sprintf("column %d", col+1), unit)
+	}
+	return lay
+}
+
+// index works out the units containing each cell and each cell's peers,
+// including the cells the chess rules make peers
+func (lay *layout) index() {
+	lay.cellUnits = make([][]int, lay.numCells())
+	for u, unit := range lay.units {
+		for _, cell := range unit {
+			lay.cellUnits[cell] = append(lay.cellUnits[cell], u)
+		}
+	}
+
+	moves := lay.chessMoves()
+	lay.peers = make([][]int, lay.numCells())
+	for cell := range lay.peers {
+		seen := map[int]bool{cell: true}
+		for _, u := range lay.cellUnits[cell] {
+			for _, peer := range lay.units[u] {
+				if !seen[peer] {
+					seen[peer] = true
+					lay.peers[cell] = append(lay.peers[cell], peer)
+				}
+			}
+		}
+		row, col := lay.rowCol(cell)
+		for _, move := range moves {
+			if peer := lay.cellAt(row+move[0], col+move[1]); peer >= 0 && !seen[peer] {
+				seen[peer] = true
+				lay.peers[cell] = append(lay.peers[cell], peer)
+			}
+		}
+	}
+}
+
+func (lay *layout) addUnit(kind unitKind, name string, cells []int) {
+	lay.units = append(lay.units, cells)
+	lay.unitKinds = append(lay.unitKinds, kind)
+	lay.unitNames = append(lay.unitNames, name)
+}
+
+// numCells returns the number of cells of the layout
+func (lay *layout) numCells() int {
+	if lay.positions != nil {
+		return len(lay.positions)
+	}
+	return lay.size * lay.size
+}
+
+// rowCol returns the board row and column of a cell
+func (lay *layout) rowCol(cell int) (int, int) {
+	if lay.positions != nil {
+		return lay.positions[cell] / lay.width, lay.positions[cell] % lay.width
+	}
+	return cell / lay.size, cell % lay.size
+}
+
+// cellAt returns the cell at a board row and column, or -1 if the board has
+// no cell there
+func (lay *layout) cellAt(row, col int) int {
+	if row < 0 || row >= lay.width || col < 0 || col >= lay.width {
+		return -1
+	}
+	if lay.boardCells != nil {
+		return lay.boardCells[row*lay.width+col]
+	}
+	return row*lay.width + col
+}
+
+// key returns the position key used in models.Puzzle for a cell
+func (lay *layout) key(cell int) string {
+	row, col := lay.rowCol(cell)
+	return cellKey(row*lay.width + col)
+}
+
+// cellOf returns the cell with a position key, or -1 if there is none
+func (lay *layout) cellOf(posKey string) int {
+	pos := cellIndex(posKey)
+	if pos < 0 {
+		return -1
+	}
+	return lay.cellAt(pos/lay.width, pos%lay.width)
+}
+
+// cellName returns the row/column name of a cell, e.g. "r3c5"
+func (lay *layout) cellName(cell int) string {
+	row, col := lay.rowCol(cell)
+	return fmt.Sprintf("r%dc%d", row+1, col+1)
+}
+
+// board is a flat, row-major working copy of a grid used by the native solver
+type board struct {
+	lay   *layout
+	rules *rules
+	cells []int // 0 means empty
+}
+
+func newBoard(grid PuzzleGrid) *board {
+	return newBoardFor(classicRules, grid)
+}
+
+func newBoardFor(r *rules, grid PuzzleGrid) *board {
+	b := &board{lay: r.lay, rules: r, cells: make([]int, r.lay.numCells())}
+	for cell := range b.cells {
+		row, col := r.lay.rowCol(cell)
+		b.cells[cell] = grid[row][col]
+	}
+	return b
+}
+
+func (b *board) grid() PuzzleGrid {
+	var grid PuzzleGrid
+	for cell, v := range b.cells {
+		row, col := b.lay.rowCol(cell)
+		grid[row][col] = v
+	}
+	return grid
+}
+
+// candidates returns the bitmask of digits not used by any peer of cell and
+// allowed by every constraint covering it
+func (b *board) candidates(cell int) uint32 {
+	mask := b.lay.full
+	for _, peer := range b.lay.peers[cell] {
+		if v := b.cells[peer]; v != 0 {
+			mask &^= 1 << uint(v)
+		}
+	}
+	for _, c := range b.rules.cellConstraints[cell] {
+		if mask == 0 {
+			break
+		}
+		mask &= b.rules.constraints[c].Allowed(b.cells, cell)
+	}
+	return mask
+}
+
+// consistent reports whether the filled cells break no rule
+func (b *board) consistent() bool {
+	for cell, v := range b.cells {
+		if v == 0 {
+			continue
+		}
+		if v < 0 || v > b.lay.size || b.candidates(cell)&(1<<uint(v)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// search runs a depth-first search that always branches on the empty cell
+// with the fewest candidates. visit is called for every solution with the
+// board filled in; returning false from visit stops the search, in which
+// case search also returns false. The search also stops once bg runs out.
+func (b *board) search(bg *budget, visit func() bool) bool {
+	if !bg.spend() {
+		return false
+	}
+
+	best, bestMask, bestCount := -1, uint32(0), b.lay.size+1
+	for cell, v := range b.cells {
+		if v != 0 {
+			continue
+		}
+		mask := b.candidates(cell)
+		if count := bits.OnesCount32(mask); count < bestCount {
+			best, bestMask, bestCount = cell, mask, count
+			if count <= 1 {
+				break
+			}
+		}
+	}
+
+	if best < 0 {
+		return visit()
+	}
+
+	for mask := bestMask; mask != 0; mask &= mask - 1 {
+		b.cells[best] = bits.TrailingZeros32(mask)
+		if !b.search(bg, visit) {
+			b.cells[best] = 0
+			return false
+		}
+	}
+	b.cells[best] = 0
+	return true
+}
+
+// NativeSolver is the pure-Go backtracking backend. It is the default and
+// needs no cgo, so it works with CGO_ENABLED=0 and when cross-compiling.
+// It is also the only backend that understands every variant rule.
+type NativeSolver struct {
+	rules *rules // nil for the classic rules
+}
+
+// newBoard builds a board under the solver's rules
+func (s NativeSolver) newBoard(grid PuzzleGrid) *board {
+	if s.rules == nil {
+		return newBoard(grid)
+	}
+	return newBoardFor(s.rules, grid)
+}
+
+// forRules returns a native solver for r, which can be any rules
+func (NativeSolver) forRules(r *rules) (Solver, bool) {
+	return NativeSolver{rules: r}, true
+}
+
+// Name returns the backend name
+func (NativeSolver) Name() string {
+	return "native"
+}
+
+// Solve fills grid in place with the first solution found
+func (s NativeSolver) Solve(ctx context.Context, grid *PuzzleGrid, limits SearchLimits) (bool, error) {
+	limits.MaxSolutions = 1
+	solutions, err := s.Solutions(ctx, *grid, limits)
+	if len(solutions) == 0 {
+		return false, err
+	}
+	*grid = solutions[0]
+	return true, nil
+}
+
+// CountSolutions returns the number of solutions of grid, up to limits.MaxSolutions
+func (s NativeSolver) CountSolutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (int, error) {
+	b := s.newBoard(grid)
+	if !b.consistent() {
+		return 0, nil
+	}
+
+	bg, cancel := newBudget(ctx, limits)
+	defer cancel()
+
+	count := 0
+	b.search(bg, func() bool {
+		count++
+		return limits.wantsMore(count)
+	})
+	return count, bg.result(count)
+}
+
+// Solutions returns up to limits.MaxSolutions distinct solutions of grid
+func (s NativeSolver) Solutions(ctx context.Context, grid PuzzleGrid, limits SearchLimits) ([]PuzzleGrid, error) {
+	b := s.newBoard(grid)
+	if !b.consistent() {
+		return nil, nil
+	}
+
+	bg, cancel := newBudget(ctx, limits)
+	defer cancel()
+
+	var solutions []PuzzleGrid
+	b.search(bg, func() bool {
+		solutions = append(solutions, b.grid())
+		return limits.wantsMore(len(solutions))
+	})
+	return solutions, bg.result(len(solutions))
+}
+
+// Candidates returns the digits not ruled out by a filled peer or a
+// constraint for each empty cell
+func (s NativeSolver) Candidates(grid PuzzleGrid) CandidateGrid {
+	var candidates CandidateGrid
+	b := s.newBoard(grid)
+	for cell, v := range b.cells {
+		if v == 0 {
+			row, col := b.lay.rowCol(cell)
+			candidates[row][col] = maskDigits(b.candidates(cell))
+		}
+	}
+	return candidates
+}
+
+// maskDigits expands a candidate bitmask into a sorted list of digits
+func maskDigits(mask uint32) []int {
+	digits := make([]int, 0, bits.OnesCount32(mask))
+	for ; mask != 0; mask &= mask - 1 {
+		digits = append(digits, bits.TrailingZeros32(mask))
+	}
+	return digits
+}
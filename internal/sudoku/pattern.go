@@ -0,0 +1,161 @@
+package sudoku
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// ParseMask parses a clue mask for a size x size grid, row by row. "1",
+// "x", "X" and "#" mark a given; "0", "." and "-" mark an empty cell.
+// Whitespace is ignored so that masks can be written as a block.
+func ParseMask(maskStr string, size int) ([]bool, error) {
+	if maskStr == "" {
+		return nil, nil
+	}
+
+	mask := make([]bool, 0, size*size)
+	clues := 0
+	for _, ch := range strings.Join(strings.Fields(maskStr), "") {
+		switch ch {
+		case '1', 'x', 'X', '#':
+			mask = append(mask, true)
+			clues++
+		case '0', '.', '-':
+			mask = append(mask, false)
+		default:
+			return nil, fmt.Errorf("invalid character %q in clue mask", ch)
+		}
+	}
+	if len(mask) != size*size {
+		return nil, fmt.Errorf("clue mask has %d cells, expected %d", len(mask), size*size)
+	}
+	if fewest, _ := ClueLimits(size); clues < fewest {
+		return nil, fmt.Errorf("clue mask has %d givens, at least %d are needed", clues, fewest)
+	}
+	return mask, nil
+}
+
+// Cutting a random solution grid down to a mask almost never leaves a
+// unique puzzle, so the values of the givens are searched for instead. The
+// search starts from a solution grid's values at the mask and changes one
+// given at a time, measuring how far the givens are from a unique puzzle by
+// the cells that a sample of their solutions disagree on. A change is kept
+// if it doesn't add to them, or now and then if it does, so that the search
+// can climb out of dead ends.
+const (
+	maskSampleSolutions = 16  // Solutions listed to measure the givens
+	maskStalledSteps    = 400 // Changes tried without getting closer before starting over
+	maskTemperature     = 3.0 // How readily a change that adds disagreeing cells is kept
+)
+
+// searchMask looks for givens at the mask's positions with a unique solution,
+// starting from solution. It returns the givens, their solution and rating,
+// and false if the search stalls or runs out of time.
+func searchMask(ctx context.Context, r *rules, solution PuzzleGrid, mask []bool, rng *rand.Rand) (PuzzleGrid, PuzzleGrid, Rating, bool) {
+	lay := r.lay
+	var givens PuzzleGrid
+	var maskCells []int
+	for cell, given := range mask {
+		if given {
+			row, col := lay.rowCol(cell)
+			givens[row][col] = solution[row][col]
+			maskCells = append(maskCells, cell)
+		}
+	}
+
+	score, sample, ok := r.maskScore(ctx, givens)
+	if !ok {
+		return givens, solution, Rating{}, false
+	}
+	best := score
+	for steps, stalled := 0, 0; score > 0 && stalled < maskStalledSteps; steps++ {
+		if ctx.Err() != nil {
+			return givens, solution, Rating{}, false
+		}
+		stalled++
+
+		// Change a given near the disagreeing cells to a digit its other
+		// peers allow
+		cell := pickMaskCell(lay, maskCells, sample, rng)
+		row, col := lay.rowCol(cell)
+		old := givens[row][col]
+		givens[row][col] = 0
+		digits := maskDigits(newBoardFor(r, givens).candidates(cell) &^ (1 << uint(old)))
+		if len(digits) == 0 {
+			givens[row][col] = old
+			continue
+		}
+		givens[row][col] = digits[rng.Intn(len(digits))]
+
+		newScore, newSample, ok := r.maskScore(ctx, givens)
+		if !ok || (newScore > score && rng.Float64() >= math.Exp(float64(score-newScore)/maskTemperature)) {
+			givens[row][col] = old
+			continue
+		}
+		score, sample = newScore, newSample
+		if score < best {
+			best, stalled = score, 0
+		}
+		utils.Log(utils.LogLevelTrace, "Mask search step %d: %d cells disagree", steps+1, score)
+	}
+	if score > 0 {
+		utils.Log(utils.LogLevelDebug, "Mask search stalled with %d cells disagreeing", best)
+		return givens, solution, Rating{}, false
+	}
+
+	// A single solution listed within the budget is the only one
+	return givens, sample[0], rateGrid(ctx, r, givens), true
+}
+
+// maskScore lists a sample of the solutions of givens and counts the cells
+// they disagree on, 0 for a unique solution. It reports false if the givens
+// have no solution or the listing runs out of budget.
+func (r *rules) maskScore(ctx context.Context, givens PuzzleGrid) (int, []PuzzleGrid, bool) {
+	limits := generatorLimits
+	limits.MaxSolutions = maskSampleSolutions
+	solutions, err := r.enumerate(ctx, givens, limits)
+	if err != nil || len(solutions) == 0 {
+		return 0, nil, false
+	}
+
+	score := 0
+	for cell := 0; cell < r.lay.numCells(); cell++ {
+		if row, col := r.lay.rowCol(cell); disagree(solutions, row, col) {
+			score++
+		}
+	}
+	return score, solutions, true
+}
+
+// pickMaskCell picks a mask cell at random, preferring those sharing a unit
+// with a cell the solutions disagree on
+func pickMaskCell(lay *layout, maskCells []int, solutions []PuzzleGrid, rng *rand.Rand) int {
+	var near []int
+	for _, cell := range maskCells {
+		for _, peer := range lay.peers[cell] {
+			if row, col := lay.rowCol(peer); disagree(solutions, row, col) {
+				near = append(near, cell)
+				break
+			}
+		}
+	}
+	if len(near) == 0 {
+		near = maskCells
+	}
+	return near[rng.Intn(len(near))]
+}
+
+// disagree reports whether solutions hold different values at a cell
+func disagree(solutions []PuzzleGrid, row, col int) bool {
+	for _, other := range solutions[1:] {
+		if other[row][col] != solutions[0][row][col] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package sudoku
+
+import (
+	"context"
+	"math"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// techniqueScores are difficulty scores per technique on a scale similar to
+// Sudoku Explainer's, so a puzzle needing an X-Wing rates about 3.2
+var techniqueScores = map[Technique]float64{
+	TechniqueHiddenSingle:     1.5,
+	TechniqueCageSum:          2.0,
+	TechniqueConstraint:       2.0,
+	TechniqueNakedSingle:      2.3,
+	TechniquePointing:         2.6,
+	TechniqueBoxLineReduction: 2.8,
+	TechniqueNakedPair:        3.0,
+	TechniqueXWing:            3.2,
+	TechniqueHiddenPair:       3.4,
+	TechniqueNakedTriple:      3.6,
+	TechniqueSwordfish:        3.8,
+	TechniqueHiddenTriple:     4.0,
+	TechniqueXYWing:           4.2,
+	TechniqueXYZWing:          4.4,
+	TechniqueNakedQuad:        5.0,
+	TechniqueJellyfish:        5.2,
+	TechniqueHiddenQuad:       5.4,
+}
+
+// unsolvedScore is the score given to puzzles the logical solver can't finish
+const unsolvedScore = 6.0
+
+// Tier names, from easiest to hardest
+const (
+	TierBeginner = "Beginner"
+	TierEasy     = "Easy"
+	TierMedium   = "Medium"
+	TierHard     = "Hard"
+	TierExpert   = "Expert"
+	TierMaster   = "Master"
+	TierExtreme  = "Extreme"
+)
+
+// tierLimits maps the highest score in each tier to its name
+var tierLimits = []struct {
+	maxScore float64
+	tier     string
+}{
+	{1.5, TierBeginner},
+	{2.3, TierEasy},
+	{2.8, TierMedium},
+	{3.4, TierHard},
+	{4.4, TierExpert},
+	{5.4, TierMaster},
+	{math.Inf(1), TierExtreme},
+}
+
+// Rating describes how hard a puzzle is to solve by logic
+type Rating struct {
+	Score   float64   `json:"score"`   // Score of the hardest technique needed
+	Effort  float64   `json:"effort"`  // Sum of the scores of every step
+	Hardest Technique `json:"hardest"` // Hardest technique needed, empty if unsolved
+	Tier    string    `json:"tier"`
+	Solved  bool      `json:"solved"` // Whether logic alone solves the puzzle
+}
+
+// difficultyBand is the range of ratings a difficulty level accepts
+type difficultyBand struct {
+	minScore  float64
+	maxScore  float64
+	maxEffort float64 // 0 means no limit
+}
+
+// difficultyBands maps difficulty levels 0-9 to rating bands. Level 0 is
+// told apart from level 1 by effort, i.e. it is given enough extra clues to
+// make the solve short.
+var difficultyBands = [10]difficultyBand{
+	{0, 1.5, 60},          // hidden singles, many clues
+	{0, 1.5, 0},           // hidden singles
+	{1.6, 2.3, 0},         // naked singles
+	{2.4, 2.6, 0},         // pointing pairs
+	{2.7, 3.0, 0},         // box/line reduction, naked pairs
+	{3.1, 3.4, 0},         // X-Wing, hidden pairs
+	{3.5, 4.0, 0},         // triples, Swordfish
+	{4.1, 4.4, 0},         // XY-Wing, XYZ-Wing
+	{4.5, 5.4, 0},         // quads, Jellyfish
+	{5.5, math.Inf(1), 0}, // beyond the logical solver
+}
+
+// RateGrid rates a grid by the techniques its logical solve needs
+func RateGrid(ctx context.Context, grid PuzzleGrid) Rating {
+	return rateGrid(ctx, classicRules, grid)
+}
+
+// rateGrid is RateGrid under the given rules
+func rateGrid(ctx context.Context, r *rules, grid PuzzleGrid) Rating {
+	result := solveLogically(ctx, r, grid)
+	rating := rateTrace(result)
+	utils.Log(utils.LogLevelDebug, "Rated grid %.1f (%s), effort %.1f, hardest technique %q",
+		rating.Score, rating.Tier, rating.Effort, rating.Hardest)
+	return rating
+}
+
+// RatePuzzle rates a puzzle by its system cells and variant rules
+func RatePuzzle(ctx context.Context, puzzle models.Puzzle) Rating {
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		utils.Log(utils.LogLevelWarn, "Can't rate puzzle %s: %v", puzzle.UUID, err)
+		return rateTrace(LogicResult{})
+	}
+	return rateGrid(ctx, r, givensGrid(puzzle))
+}
+
+// ApplyRating stores a rating on a puzzle
+func ApplyRating(puzzle *models.Puzzle, rating Rating) {
+	puzzle.Rating = rating.Score
+	puzzle.Tier = rating.Tier
+}
+
+// EnsureRating rates a puzzle saved before ratings were stored, also giving
+// it a difficulty level if it has none, and reports whether the puzzle changed.
+// Only puzzles with a unique solution are rated; others are left unrated so
+// the next load tries again.
+func EnsureRating(puzzle *models.Puzzle) bool {
+	if puzzle.Tier != "" {
+		return false
+	}
+	ctx := context.Background()
+	if _, err := EnsureSolution(ctx, puzzle, generatorLimits); err != nil {
+		utils.Log(utils.LogLevelWarn, "Not rating puzzle %s: %v", puzzle.UUID, err)
+		return false
+	}
+	rating := RatePuzzle(ctx, *puzzle)
+	ApplyRating(puzzle, rating)
+	if puzzle.Difficulty < 1 || puzzle.Difficulty > 9 {
+		puzzle.Difficulty = LevelForRating(rating)
+	}
+	return true
+}
+
+// rateTrace scores the steps of a logical solve
+func rateTrace(result LogicResult) Rating {
+	rating := Rating{Solved: result.Solved}
+	for _, step := range result.Steps {
+		score := techniqueScores[step.Technique]
+		rating.Effort += score
+		if score > rating.Score {
+			rating.Score = score
+			rating.Hardest = step.Technique
+		}
+	}
+	if !result.Solved {
+		rating.Score = unsolvedScore
+		rating.Hardest = ""
+	}
+	rating.Effort = math.Round(rating.Effort*10) / 10
+	rating.Tier = tierForScore(rating.Score)
+	return rating
+}
+
+func tierForScore(score float64) string {
+	for _, limit := range tierLimits {
+		if score <= limit.maxScore {
+			return limit.tier
+		}
+	}
+	return TierExtreme
+}
+
+// bandForLevel returns the rating band of a difficulty level
+func bandForLevel(level int) difficultyBand {
+	if level < 0 {
+		level = 0
+	} else if level >= len(difficultyBands) {
+		level = len(difficultyBands) - 1
+	}
+	return difficultyBands[level]
+}
+
+// bandForTechnique returns the rating band of puzzles whose hardest step is
+// the given technique. Every technique has its own score, so this is the
+// band of puzzles needing it and nothing harder.
+func bandForTechnique(technique Technique) difficultyBand {
+	score := techniqueScores[technique]
+	return difficultyBand{minScore: score, maxScore: score}
+}
+
+// compare reports whether rating is below (-1), inside (0) or above (1) the band
+func (band difficultyBand) compare(rating Rating) int {
+	switch {
+	case rating.Score < band.minScore:
+		return -1
+	case rating.Score > band.maxScore:
+		return 1
+	case band.maxEffort > 0 && rating.Effort > band.maxEffort:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// distance is how far a rating lies outside the band, 0 if it is inside
+func (band difficultyBand) distance(rating Rating) float64 {
+	switch {
+	case rating.Score < band.minScore:
+		return band.minScore - rating.Score
+	case rating.Score > band.maxScore:
+		return rating.Score - band.maxScore
+	case band.maxEffort > 0 && rating.Effort > band.maxEffort:
+		// Too much effort is only a small miss compared to the wrong tier
+		return (rating.Effort - band.maxEffort) / 1000
+	default:
+		return 0
+	}
+}
+
+// LevelForRating returns the lowest difficulty level whose band contains rating
+func LevelForRating(rating Rating) int {
+	for level, band := range difficultyBands {
+		if band.compare(rating) == 0 {
+			return level
+		}
+	}
+	return len(difficultyBands) - 1
+}
@@ -0,0 +1,118 @@
+package sudoku
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Extra regions a puzzle can add on top of rows, columns and boxes. Like a
+// box, each region must hold every digit exactly once.
+const (
+	RegionDiagonal = "diagonal" // Both main diagonals (Sudoku X)
+	RegionWindoku  = "windoku"  // Four extra 3x3 windows (Windoku or Hyper Sudoku)
+)
+
+// extraRegionOrder lists every extra region in the order they are stored
+var extraRegionOrder = []string{RegionDiagonal, RegionWindoku}
+
+// extraRegionUnits maps each extra region to a function adding its units to
+// a 9x9 layout
+var extraRegionUnits = map[string]func(lay *layout){
+	RegionDiagonal: func(lay *layout) {
+		main := make([]int, 0, 9)
+		anti := make([]int, 0, 9)
+		for i := 0; i < 9; i++ {
+			main = append(main, i*9+i)
+			anti = append(anti, i*9+8-i)
+		}
+		lay.addUnit(unitExtra, "main diagonal", main)
+		lay.addUnit(unitExtra, "anti-diagonal", anti)
+	},
+	RegionWindoku: func(lay *layout) {
+		window := 0
+		for _, top := range []int{1, 5} {
+			for _, left := range []int{1, 5} {
+				unit := make([]int, 0, 9)
+				for row := top; row < top+3; row++ {
+					for col := left; col < left+3; col++ {
+						unit = append(unit, row*9+col)
+					}
+				}
+				window++
+				lay.addUnit(unitExtra, fmt.Sprintf("window %d", window), unit)
+			}
+		}
+	},
+}
+
+// ParseExtraRegions parses a comma separated list of extra regions, e.g.
+// "diagonal,windoku", into their stored order
+func ParseExtraRegions(str string) ([]string, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(str, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := extraRegionUnits[name]; !ok {
+			return nil, fmt.Errorf("unknown region %q", name)
+		}
+		wanted[name] = true
+	}
+
+	var regions []string
+	for _, name := range extraRegionOrder {
+		if wanted[name] {
+			regions = append(regions, name)
+		}
+	}
+	return regions, nil
+}
+
+// withExtraRegions returns a copy of the layout with extra region units added
+func (lay *layout) withExtraRegions(regions []string) (*layout, error) {
+	if len(regions) == 0 {
+		return lay, nil
+	}
+	if lay.size != 9 {
+		return nil, invalidPuzzle("extra regions need a 9x9 grid, not %dx%d", lay.size, lay.size)
+	}
+
+	extended := &layout{
+		size:      lay.size,
+		width:     lay.width,
+		full:      lay.full,
+		units:     append([][]int{}, lay.units...),
+		unitKinds: append([]unitKind{}, lay.unitKinds...),
+		unitNames: append([]string{}, lay.unitNames...),
+		regionMap: lay.regionMap,
+
+		positions:  lay.positions,
+		boardCells: lay.boardCells,
+		antiKnight: lay.antiKnight,
+		antiKing:   lay.antiKing,
+	}
+	for _, name := range regions {
+		addUnits, ok := extraRegionUnits[name]
+		if !ok {
+			return nil, invalidPuzzle("unknown region %q", name)
+		}
+		if stringsContain(extended.extraRegions, name) {
+			return nil, invalidPuzzle("region %q is listed twice", name)
+		}
+		addUnits(extended)
+		extended.extraRegions = append(extended.extraRegions, name)
+	}
+	extended.index()
+	return extended, nil
+}
+
+func stringsContain(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,306 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// Repairing a puzzle with several solutions means keeping one of them and
+// giving enough of its values that no other solution fits. A new given
+// rules out every other solution differing from the kept one in that cell,
+// so the fewest givens are a smallest set of cells meeting the differences
+// from every other solution. There are usually far too many solutions to
+// list, so they are listed a few at a time: the best givens for those found
+// so far are tried, and any solutions that still fit are added to the list.
+const (
+	repairSolutions   = 20    // Solutions listed per round
+	repairTargets     = 3     // Solutions tried as the one to keep
+	repairSearchNodes = 20000 // Search nodes spent proving the fewest givens per round
+)
+
+// Repair lists the givens that make an ambiguous puzzle unique
+type Repair struct {
+	Givens  []Placement `json:"givens"`  // Values to give, from the solution kept
+	Minimum bool        `json:"minimum"` // No fewer givens make the kept solution unique
+}
+
+// RepairPuzzle works out a small set of extra givens that leave a puzzle's
+// system cells with a single solution, and returns them along with the
+// repaired puzzle: the givens added as system cells, and its solution and
+// rating stored. Each of the first few solutions is tried as the one to
+// keep, and the fewest givens for each are searched for; Minimum reports
+// whether that search finished. A puzzle that already has a unique
+// solution needs no givens. ErrNoSolution is returned if it has none.
+func RepairPuzzle(ctx context.Context, puzzle models.Puzzle, limits SearchLimits) (Repair, models.Puzzle, error) {
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return Repair{}, puzzle, err
+	}
+	if limits.Timeout > 0 {
+		// The limits apply to the whole repair rather than to each search
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+		limits.Timeout = 0
+	}
+	limits.MaxSolutions = repairSolutions
+
+	grid := givensGrid(puzzle)
+	solutions, err := r.enumerate(ctx, grid, limits)
+	if err != nil {
+		return Repair{}, puzzle, err
+	}
+	if len(solutions) == 0 {
+		return Repair{}, puzzle, ErrNoSolution
+	}
+
+	// A puzzle with a unique solution keeps it and needs no givens
+	targets := solutions
+	if len(targets) == 1 {
+		targets = nil
+	} else if len(targets) > repairTargets {
+		targets = targets[:repairTargets]
+	}
+	var best []int
+	minimum := true
+	kept := solutions[0]
+	for _, target := range targets {
+		cells, proven, err := r.repairFor(ctx, grid, target, solutions, limits)
+		var budgetErr *BudgetExceededError
+		if errors.As(err, &budgetErr) && best != nil {
+			// Settle for the best repair found in time
+			break
+		} else if err != nil {
+			return Repair{}, puzzle, err
+		}
+		if best == nil || len(cells) < len(best) {
+			best, minimum, kept = cells, proven, target
+		}
+	}
+
+	// Add the givens to a copy of the puzzle
+	lay := r.lay
+	repair := Repair{Givens: []Placement{}, Minimum: minimum}
+	repaired := puzzle
+	repaired.Cells = make(map[string]models.Cell, len(puzzle.Cells))
+	for posKey, cell := range puzzle.Cells {
+		repaired.Cells[posKey] = cell
+	}
+	sort.Ints(best)
+	for _, cell := range best {
+		row, col := lay.rowCol(cell)
+		given := Placement{Cell: lay.key(cell), Value: kept[row][col]}
+		repair.Givens = append(repair.Givens, given)
+		repaired.Cells[given.Cell] = models.Cell{Value: given.Value, Notes: []int{}, Status: "s"}
+	}
+	storeSolution(&repaired, kept)
+	ApplyRating(&repaired, RatePuzzle(ctx, repaired))
+	repaired.Signature = Signature(repaired)
+
+	utils.Log(utils.LogLevelInfo, "Puzzle %s needs %d more givens for a unique solution (minimum %v)",
+		puzzle.UUID, len(repair.Givens), repair.Minimum)
+	return repair, repaired, nil
+}
+
+// repairFor returns the fewest cells it can find whose values in target
+// leave target the only solution of grid, and whether they were proven to
+// be the fewest. known holds solutions of grid found already.
+func (r *rules) repairFor(ctx context.Context, grid, target PuzzleGrid, known []PuzzleGrid, limits SearchLimits) ([]int, bool, error) {
+	lay := r.lay
+	var differences []cellSet
+	addSolution := func(solution PuzzleGrid) {
+		diff := newCellSet(lay.numCells())
+		for cell := 0; cell < lay.numCells(); cell++ {
+			row, col := lay.rowCol(cell)
+			if solution[row][col] != target[row][col] {
+				diff.add(cell)
+			}
+		}
+		if !diff.empty() {
+			differences = append(differences, diff)
+		}
+	}
+	for _, solution := range known {
+		addSolution(solution)
+	}
+
+	for round := 1; ; round++ {
+		if err := ctx.Err(); err != nil {
+			return nil, false, &BudgetExceededError{Cause: err}
+		}
+		cells, minimum := hittingSet(differences, lay.numCells(), repairSearchNodes)
+		candidate := grid
+		for _, cell := range cells {
+			row, col := lay.rowCol(cell)
+			candidate[row][col] = target[row][col]
+		}
+
+		solutions, err := r.enumerate(ctx, candidate, limits)
+		if err != nil {
+			return nil, false, err
+		}
+		utils.Log(utils.LogLevelDebug, "Repair round %d: %d givens leave %d solutions", round, len(cells), len(solutions))
+		if len(solutions) == 1 {
+			return cells, minimum, nil
+		}
+		for _, solution := range solutions {
+			addSolution(solution)
+		}
+	}
+}
+
+// cellSet is a set of cells, one bit per cell
+type cellSet []uint64
+
+func newCellSet(cells int) cellSet {
+	return make(cellSet, (cells+63)/64)
+}
+
+func (s cellSet) add(cell int) {
+	s[cell/64] |= 1 << uint(cell%64)
+}
+
+func (s cellSet) remove(cell int) {
+	s[cell/64] &^= 1 << uint(cell%64)
+}
+
+func (s cellSet) has(cell int) bool {
+	return s[cell/64]&(1<<uint(cell%64)) != 0
+}
+
+func (s cellSet) empty() bool {
+	return s.count() == 0
+}
+
+func (s cellSet) count() int {
+	n := 0
+	for _, word := range s {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// meets reports whether two sets share a cell
+func (s cellSet) meets(other cellSet) bool {
+	for i := range s {
+		if s[i]&other[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hittingSet returns a smallest set of cells meeting every one of sets. A
+// greedy choice gives an upper bound and sets sharing no cells a lower
+// bound; the sizes in between are searched. If that takes more than
+// maxNodes search nodes it settles for the greedy choice and reports false.
+func hittingSet(sets []cellSet, cells, maxNodes int) ([]int, bool) {
+	greedy := greedyHittingSet(sets, cells)
+	nodes := 0
+	chosen := newCellSet(cells)
+	var picked []int
+
+	// search picks up to size more cells, branching on the cells of the
+	// smallest set not yet met
+	var search func(size int) bool
+	search = func(size int) bool {
+		nodes++
+		var unmet []cellSet
+		for _, set := range sets {
+			if !set.meets(chosen) {
+				unmet = append(unmet, set)
+			}
+		}
+		if len(unmet) == 0 {
+			return true
+		}
+		if size == 0 || nodes > maxNodes || disjointCount(unmet) > size {
+			return false
+		}
+
+		smallest := unmet[0]
+		for _, set := range unmet[1:] {
+			if set.count() < smallest.count() {
+				smallest = set
+			}
+		}
+		for cell := 0; cell < cells; cell++ {
+			if !smallest.has(cell) {
+				continue
+			}
+			chosen.add(cell)
+			picked = append(picked, cell)
+			if search(size - 1) {
+				return true
+			}
+			picked = picked[:len(picked)-1]
+			chosen.remove(cell)
+		}
+		return false
+	}
+
+	for size := disjointCount(sets); size < len(greedy); size++ {
+		if search(size) {
+			return picked, true
+		}
+		if nodes > maxNodes {
+			return greedy, false
+		}
+	}
+	return greedy, true
+}
+
+// disjointCount returns the size of a group of sets sharing no cells, picked
+// greedily. Each needs a cell of its own, so no fewer cells can meet them all.
+func disjointCount(sets []cellSet) int {
+	if len(sets) == 0 {
+		return 0
+	}
+	covered := newCellSet(len(sets[0]) * 64)
+	count := 0
+	for _, set := range sets {
+		if !set.meets(covered) {
+			count++
+			for i := range covered {
+				covered[i] |= set[i]
+			}
+		}
+	}
+	return count
+}
+
+// greedyHittingSet meets every one of sets by repeatedly picking the cell
+// in the most sets not yet met
+func greedyHittingSet(sets []cellSet, cells int) []int {
+	var picked []int
+	unmet := append([]cellSet{}, sets...)
+	for len(unmet) > 0 {
+		bestCell, bestCount := 0, 0
+		for cell := 0; cell < cells; cell++ {
+			count := 0
+			for _, set := range unmet {
+				if set.has(cell) {
+					count++
+				}
+			}
+			if count > bestCount {
+				bestCell, bestCount = cell, count
+			}
+		}
+		picked = append(picked, bestCell)
+
+		remaining := unmet[:0]
+		for _, set := range unmet {
+			if !set.has(bestCell) {
+				remaining = append(remaining, set)
+			}
+		}
+		unmet = remaining
+	}
+	return picked
+}
@@ -0,0 +1,54 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRepairPuzzle(t *testing.T) {
+	tests := []struct {
+		name       string
+		grid       string
+		wantGivens bool
+	}{
+		{"unique", classicPuzzle, false},
+		{"two givens missing", "000070000" + classicPuzzle[9:], true},
+		{"a row missing", "000000000" + classicPuzzle[9:], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puzzle := givensPuzzle(parseGrid(t, tt.grid, 9), classicLayout)
+			repair, repaired, err := RepairPuzzle(context.Background(), puzzle, SearchLimits{})
+			if err != nil {
+				t.Fatalf("RepairPuzzle: %v", err)
+			}
+			if (len(repair.Givens) > 0) != tt.wantGivens {
+				t.Errorf("%d givens added", len(repair.Givens))
+			}
+			for _, given := range repair.Givens {
+				if cell := repaired.Cells[given.Cell]; cell.Value != given.Value || cell.Status != "s" {
+					t.Errorf("given %d at %s is %+v in the repaired puzzle", given.Value, given.Cell, cell)
+				}
+				if puzzle.Cells[given.Cell].Value != 0 {
+					t.Errorf("given at %s was already filled", given.Cell)
+				}
+			}
+
+			uniqueness, err := CheckUniqueness(context.Background(), givensGrid(repaired), SearchLimits{})
+			if err != nil || uniqueness != UniqueSolution {
+				t.Errorf("repaired puzzle has %v solutions (%v)", uniqueness, err)
+			}
+			if solution, ok := storedSolution(repaired); !ok || !newBoardFor(classicRules, solution).consistent() {
+				t.Errorf("repaired puzzle has no sound stored solution")
+			}
+		})
+	}
+}
+
+func TestRepairPuzzleWithoutSolution(t *testing.T) {
+	puzzle := givensPuzzle(parseGrid(t, "55"+classicPuzzle[2:], 9), classicLayout)
+	if _, _, err := RepairPuzzle(context.Background(), puzzle, SearchLimits{}); !errors.Is(err, ErrNoSolution) {
+		t.Errorf("RepairPuzzle error %v, want %v", err, ErrNoSolution)
+	}
+}
@@ -0,0 +1,208 @@
+package sudoku
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+	"github.com/google/uuid"
+)
+
+// A reskin is an isomorph of a puzzle: the digits are relabelled, the bands,
+// stacks, rows and columns reordered and the grid rotated or reflected. To a
+// solver it is the same puzzle, needing the same techniques in the same
+// places, but a player won't recognise it.
+
+// geometricTransforms are the rotations and reflections of the grid. last is
+// the index of the last row and column.
+var geometricTransforms = []func(last, row, col int) (int, int){
+	func(last, row, col int) (int, int) { return row, col },
+	func(last, row, col int) (int, int) { return col, last - row },        // 90° rotation
+	func(last, row, col int) (int, int) { return last - row, last - col }, // 180° rotation
+	func(last, row, col int) (int, int) { return last - col, row },        // 270° rotation
+	func(last, row, col int) (int, int) { return row, last - col },        // Reflection in the middle column
+	func(last, row, col int) (int, int) { return last - row, col },        // Reflection in the middle row
+	func(last, row, col int) (int, int) { return col, row },               // Reflection in the main diagonal
+	func(last, row, col int) (int, int) { return last - col, last - row }, // Reflection in the anti-diagonal
+}
+
+// rowPreservingTransforms are the geometric transforms that keep rows as
+// rows, the only ones allowed when boxes aren't square
+var rowPreservingTransforms = []int{0, 2, 4, 5}
+
+// symmetryKeeping describes the transforms that keep a symmetry of the clue
+// pattern: the geometric transforms allowed, whether rows and columns must
+// be reordered alike, and whether they must be reordered symmetrically about
+// the centre of the grid
+type symmetryKeeping struct {
+	geometric   []int
+	alike       bool
+	centredRows bool
+	centredCols bool
+}
+
+var keepSymmetry = map[string]symmetryKeeping{
+	SymmetryNone:         {geometric: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+	SymmetryRotational:   {geometric: []int{0, 1, 2, 3, 4, 5, 6, 7}, centredRows: true, centredCols: true},
+	SymmetryDiagonal:     {geometric: []int{0, 2, 6, 7}, alike: true},
+	SymmetryMirror:       {geometric: []int{0, 2, 4, 5}, centredCols: true},
+	SymmetryRotational90: {geometric: []int{0, 1, 2, 3, 4, 5, 6, 7}, alike: true, centredRows: true, centredCols: true},
+}
+
+// ReskinPuzzle returns a random isomorph of a puzzle under a new UUID, with
+// Source pointing back at the original. Only the givens are carried over;
+// the rating, difficulty and symmetry are kept exactly, since the isomorph
+// needs the same techniques. A seed of 0 picks a random one. Variant rules
+// aren't kept by every transform, so only puzzles without them can be
+// reskinned; ErrInvalidPuzzle is returned for the rest.
+func ReskinPuzzle(ctx context.Context, puzzle models.Puzzle, seed int64, limits SearchLimits) (models.Puzzle, error) {
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return models.Puzzle{}, err
+	}
+	if r != sizeRules[r.lay.size] {
+		return models.Puzzle{}, invalidPuzzle("only puzzles without variant rules can be reskinned")
+	}
+	keeping, ok := keepSymmetry[puzzle.Symmetry]
+	if !ok {
+		return models.Puzzle{}, invalidPuzzle("unknown symmetry %q", puzzle.Symmetry)
+	}
+	solution, err := solutionFor(ctx, puzzle, limits)
+	if err != nil {
+		return models.Puzzle{}, err
+	}
+
+	if seed == 0 {
+		seed = NewSeed()
+	}
+	utils.Log(utils.LogLevelInfo, "Reskinning puzzle %s with seed %d", puzzle.UUID, seed)
+	rng := rand.New(rand.NewSource(seed))
+	lay := r.lay
+	shape := boxShapes[lay.size]
+
+	// Pick the transforms, keeping the symmetry of the clue pattern
+	geometric := keeping.geometric
+	if shape[0] != shape[1] {
+		geometric = intersectInts(geometric, rowPreservingTransforms)
+	}
+	transform := geometricTransforms[geometric[rng.Intn(len(geometric))]]
+	rows := randomLineOrder(shape[0], lay.size/shape[0], keeping.centredRows, rng)
+	cols := randomLineOrder(shape[1], lay.size/shape[1], keeping.centredCols, rng)
+	if keeping.alike {
+		if shape[0] != shape[1] {
+			// Rows and columns come in groups of different sizes, so
+			// the only order that suits both is leaving them be
+			rows = rows[:0]
+			for line := 0; line < lay.size; line++ {
+				rows = append(rows, line)
+			}
+		}
+		cols = rows
+	}
+	digits := append([]int{0}, rng.Perm(lay.size)...)
+	for d := 1; d <= lay.size; d++ {
+		digits[d]++
+	}
+
+	// Each cell of the reskin takes the relabelled value of the cell it comes from
+	givens := givensGrid(puzzle)
+	var grid, reskinSolution PuzzleGrid
+	for row := 0; row < lay.size; row++ {
+		for col := 0; col < lay.size; col++ {
+			fromRow, fromCol := transform(lay.size-1, row, col)
+			fromRow, fromCol = rows[fromRow], cols[fromCol]
+			grid[row][col] = digits[givens[fromRow][fromCol]]
+			reskinSolution[row][col] = digits[solution[fromRow][fromCol]]
+		}
+	}
+
+	reskin := models.Puzzle{
+		UUID:       uuid.New().String(),
+		Cells:      gridToCells(grid, lay),
+		CreatedAt:  time.Now().Format(time.RFC3339),
+		Difficulty: puzzle.Difficulty,
+		Size:       puzzle.Size,
+		Symbols:    puzzle.Symbols,
+		Rating:     puzzle.Rating,
+		Tier:       puzzle.Tier,
+		OffBand:    puzzle.OffBand,
+		Symmetry:   puzzle.Symmetry,
+		Technique:  puzzle.Technique,
+		Source:     puzzle.UUID,
+	}
+	for posKey, cell := range reskin.Cells {
+		if cell.Value != 0 {
+			cell.Status = "s"
+			reskin.Cells[posKey] = cell
+		}
+	}
+	storeSolution(&reskin, reskinSolution)
+	reskin.Signature = Signature(reskin)
+
+	utils.Log(utils.LogLevelInfo, "Reskinned puzzle %s as %s", puzzle.UUID, reskin.UUID)
+	return reskin, nil
+}
+
+// randomLineOrder returns a random order of groups*groupSize lines that keeps
+// each group together. A centred order sends lines the same distance either
+// side of the centre to lines the same distance either side, which keeps
+// rotational and mirror symmetry.
+func randomLineOrder(groupSize, groups int, centred bool, rng *rand.Rand) []int {
+	groupOrder := randomPerm(groups, centred, rng)
+	within := make([][]int, groups)
+	for g := range within {
+		mirror := groups - 1 - g
+		switch {
+		case !centred:
+			within[g] = rng.Perm(groupSize)
+		case g == mirror:
+			within[g] = randomPerm(groupSize, true, rng)
+		case g < mirror:
+			within[g] = rng.Perm(groupSize)
+			within[mirror] = make([]int, groupSize)
+			for i, line := range within[g] {
+				within[mirror][groupSize-1-i] = groupSize - 1 - line
+			}
+		}
+	}
+
+	order := make([]int, 0, groups*groupSize)
+	for g, group := range groupOrder {
+		for _, line := range within[g] {
+			order = append(order, group*groupSize+line)
+		}
+	}
+	return order
+}
+
+// randomPerm returns a random ordering of 0..n-1. A centred ordering sends i
+// and n-1-i to j and n-1-j for some j.
+func randomPerm(n int, centred bool, rng *rand.Rand) []int {
+	if !centred {
+		return rng.Perm(n)
+	}
+	perm := make([]int, n)
+	if n%2 == 1 {
+		perm[n/2] = n / 2
+	}
+	for i, j := range rng.Perm(n / 2) {
+		if rng.Intn(2) == 1 {
+			j = n - 1 - j
+		}
+		perm[i], perm[n-1-i] = j, n-1-j
+	}
+	return perm
+}
+
+// intersectInts returns the values of a that are also in b
+func intersectInts(a, b []int) []int {
+	var both []int
+	for _, v := range a {
+		if intsContain(b, v) {
+			both = append(both, v)
+		}
+	}
+	return both
+}
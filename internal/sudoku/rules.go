@@ -0,0 +1,176 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// ErrInvalidPuzzle is returned when a puzzle's variant settings don't make sense
+var ErrInvalidPuzzle = errors.New("invalid puzzle")
+
+// Constraint is a rule over a group of cells on top of the layout's units,
+// such as a killer cage or a thermometer. The native solver consults it while
+// searching and the logical solver uses it to rule out candidates. Cells are
+// 0-based indexes into the board, in row-major order on a single grid.
+type Constraint interface {
+	// Cells returns the cells the constraint covers
+	Cells() []int
+	// Allowed returns the digits cell may hold given the values of the
+	// constraint's other cells, ignoring any value cell itself holds
+	Allowed(values []int, cell int) uint32
+	// Technique names the logical step that applies the constraint
+	Technique() Technique
+	// Describe names the constraint in step descriptions, e.g. "the 12 cage
+	// at r1c1", using cellName to name its cells
+	Describe(cellName func(cell int) string) string
+}
+
+// NewConstraints builds the constraints a puzzle's cages and variant
+// constraints stand for, checking them against its layout. ErrInvalidPuzzle
+// is returned for an unknown constraint type or cells that don't fit it.
+func NewConstraints(puzzle models.Puzzle) ([]Constraint, error) {
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return nil, err
+	}
+	return r.constraints, nil
+}
+
+// rules describe what makes a grid a solution: the units and peers of its
+// layout plus any constraints
+type rules struct {
+	lay             *layout
+	constraints     []Constraint
+	cellConstraints [][]int // for each cell, the indexes of the constraints covering it
+}
+
+// classicRules are the plain row, column and box rules
+var classicRules = newRules(classicLayout, nil)
+
+func newRules(lay *layout, constraints []Constraint) *rules {
+	r := &rules{
+		lay:             lay,
+		constraints:     constraints,
+		cellConstraints: make([][]int, lay.numCells()),
+	}
+	for i, c := range constraints {
+		for _, cell := range c.Cells() {
+			r.cellConstraints[cell] = append(r.cellConstraints[cell], i)
+		}
+	}
+	return r
+}
+
+// rulesFor builds the rules of a puzzle from its variant settings
+func rulesFor(puzzle models.Puzzle) (*rules, error) {
+	if len(puzzle.Grids) > 0 {
+		return multiGridRules(puzzle)
+	}
+	base, err := rulesForSize(puzzleSize(puzzle))
+	if err != nil {
+		return nil, err
+	}
+	if puzzle.RegionMap == nil && len(puzzle.ExtraRegions) == 0 && len(puzzle.Cages) == 0 && len(puzzle.Constraints) == 0 &&
+		!puzzle.AntiKnight && !puzzle.AntiKing {
+		return base, nil
+	}
+
+	lay := base.lay
+	if puzzle.RegionMap != nil {
+		if lay.size != ClassicSize {
+			return nil, invalidPuzzle("jigsaw regions need a 9x9 grid, not %dx%d", lay.size, lay.size)
+		}
+		if lay, err = newJigsawLayout(puzzle.RegionMap); err != nil {
+			return nil, err
+		}
+	}
+	if lay, err = lay.withExtraRegions(puzzle.ExtraRegions); err != nil {
+		return nil, err
+	}
+	lay = lay.withChessRules(puzzle.AntiKnight, puzzle.AntiKing)
+
+	var constraints []Constraint
+	cages, err := cagesFromModel(lay, puzzle.Cages)
+	if err != nil {
+		return nil, err
+	}
+	constraints = append(constraints, cages...)
+	variants, err := constraintsFromModel(lay, puzzle.Constraints)
+	if err != nil {
+		return nil, err
+	}
+	constraints = append(constraints, variants...)
+	return newRules(lay, constraints), nil
+}
+
+// withConstraints returns a copy of the rules with more constraints added
+func (r *rules) withConstraints(constraints ...Constraint) *rules {
+	all := append(append([]Constraint{}, r.constraints...), constraints...)
+	return newRules(r.lay, all)
+}
+
+// solver returns the backend that searches under these rules: the selected
+// backend if it can express them, otherwise the native solver, which
+// understands every rule
+func (r *rules) solver() Solver {
+	active := ActiveSolver()
+	if r == classicRules {
+		return active
+	}
+	if variant, ok := active.(variantSolver); ok {
+		if solver, ok := variant.forRules(r); ok {
+			return solver
+		}
+	}
+	return NativeSolver{rules: r}
+}
+
+// enumerate lists up to limits.MaxSolutions solutions of grid under the rules
+func (r *rules) enumerate(ctx context.Context, grid PuzzleGrid, limits SearchLimits) ([]PuzzleGrid, error) {
+	if enumerator, ok := r.solver().(Enumerator); ok {
+		return enumerator.Solutions(ctx, grid, limits)
+	}
+	return NativeSolver{rules: r}.Solutions(ctx, grid, limits)
+}
+
+// checkUniqueness is CheckUniqueness under the rules
+func (r *rules) checkUniqueness(ctx context.Context, grid PuzzleGrid, limits SearchLimits) (Uniqueness, error) {
+	limits.MaxSolutions = 2
+	count, err := r.solver().CountSolutions(ctx, grid, limits)
+	if err != nil {
+		return NoSolution, err
+	}
+	return Uniqueness(count), nil
+}
+
+// broken returns the indexes of the constraints a grid breaks. Only
+// constraints whose cells are all filled are checked.
+func (r *rules) broken(grid PuzzleGrid) []int {
+	values := newBoardFor(r, grid).cells
+
+	var broken []int
+	for i, c := range r.constraints {
+		filled := true
+		for _, cell := range c.Cells() {
+			filled = filled && values[cell] != 0
+		}
+		if !filled {
+			continue
+		}
+		for _, cell := range c.Cells() {
+			if c.Allowed(values, cell)&(1<<uint(values[cell])) == 0 {
+				broken = append(broken, i)
+				break
+			}
+		}
+	}
+	return broken
+}
+
+// invalidPuzzle wraps a description of a bad variant setting in ErrInvalidPuzzle
+func invalidPuzzle(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPuzzle, fmt.Sprintf(format, args...))
+}
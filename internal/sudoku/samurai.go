@@ -0,0 +1,173 @@
+package sudoku
+
+import (
+	"fmt"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// A multi-grid puzzle places several 9x9 grids on one larger board. Every
+// grid follows the classic rules, and a cell where grids overlap belongs to
+// all of them, so a digit placed there counts in each grid. Samurai Sudoku
+// is the best known: five grids on a 21x21 board, one in each corner and one
+// in the centre sharing a corner box with each of the others.
+
+// SamuraiSize is the number of rows and columns of a Samurai board
+const SamuraiSize = 21
+
+// samuraiGrids places the five grids of a Samurai: top left, top right,
+// centre, bottom left and bottom right
+var samuraiGrids = []models.Grid{{Row: 0, Col: 0}, {Row: 0, Col: 12}, {Row: 6, Col: 6}, {Row: 12, Col: 0}, {Row: 12, Col: 12}}
+
+// samuraiRules are the rules of every Samurai board
+var samuraiRules = newRules(mustMultiGridLayout(SamuraiSize, samuraiGrids), nil)
+
+// SamuraiGrids returns the positions of the five grids of a Samurai
+func SamuraiGrids() []models.Grid {
+	return append([]models.Grid{}, samuraiGrids...)
+}
+
+// newMultiGridLayout builds the layout of 9x9 grids placed on a width x
+// width board. Boxes shared by two grids are only added once.
+func newMultiGridLayout(width int, grids []models.Grid) (*layout, error) {
+	const size = ClassicSize
+	if width < size || width > MaxGridSize {
+		return nil, invalidPuzzle("board size %d must lie between %d and %d", width, size, MaxGridSize)
+	}
+	if len(grids) < 2 {
+		return nil, invalidPuzzle("a multi-grid puzzle needs at least 2 grids, not %d", len(grids))
+	}
+
+	lay := &layout{
+		size:       size,
+		width:      width,
+		full:       uint32(1)<<uint(size+1) - 2,
+		boardCells: make([]int, width*width),
+	}
+
+	// Number the cells covered by any grid row by row across the board
+	covered := make([]bool, width*width)
+	for i, g := range grids {
+		if g.Row < 0 || g.Col < 0 || g.Row+size > width || g.Col+size > width {
+			return nil, invalidPuzzle("grid %d at row %d, column %d doesn't fit on a %dx%d board", i+1, g.Row, g.Col, width, width)
+		}
+		for _, other := range grids[:i] {
+			if other == g {
+				return nil, invalidPuzzle("grid %d is listed twice", i+1)
+			}
+		}
+		for row := g.Row; row < g.Row+size; row++ {
+			for col := g.Col; col < g.Col+size; col++ {
+				covered[row*width+col] = true
+			}
+		}
+	}
+	for pos := range lay.boardCells {
+		lay.boardCells[pos] = -1
+		if covered[pos] {
+			lay.boardCells[pos] = len(lay.positions)
+			lay.positions = append(lay.positions, pos)
+		}
+	}
+
+	boxes := make(map[int]bool)
+	for i, g := range grids {
+		for row := 0; row < size; row++ {
+			unit := make([]int, 0, size)
+			for col := 0; col < size; col++ {
+				unit = append(unit, lay.cellAt(g.Row+row, g.Col+col))
+			}
+			lay.addUnit(unitRow, fmt.Sprintf("row %d of grid %d", row+1, i+1), unit)
+		}
+		for col := 0; col < size; col++ {
+			unit := make([]int, 0, size)
+			for row := 0; row < size; row++ {
+				unit = append(unit, lay.cellAt(g.Row+row, g.Col+col))
+			}
+			lay.addUnit(unitColumn, fmt.Sprintf("column %d of grid %d", col+1, i+1), unit)
+		}
+		for box := 0; box < size; box++ {
+			top, left := g.Row+box/3*3, g.Col+box%3*3
+			if boxes[top*width+left] {
+				continue
+			}
+			boxes[top*width+left] = true
+			unit := make([]int, 0, size)
+			for row := top; row < top+3; row++ {
+				for col := left; col < left+3; col++ {
+					unit = append(unit, lay.cellAt(row, col))
+				}
+			}
+			lay.addUnit(unitBox, fmt.Sprintf("box %d of grid %d", box+1, i+1), unit)
+		}
+	}
+
+	lay.index()
+	return lay, nil
+}
+
+// multiGridRules builds the rules of a multi-grid puzzle. The grids take the
+// place of the boxes, so they can't be combined with jigsaw or extra regions,
+// nor with cages; chess rules and variant constraints apply across the board.
+func multiGridRules(puzzle models.Puzzle) (*rules, error) {
+	if puzzle.RegionMap != nil || len(puzzle.ExtraRegions) > 0 || len(puzzle.Cages) > 0 {
+		return nil, invalidPuzzle("multi-grid puzzles can't have jigsaw regions, extra regions or cages")
+	}
+	base := samuraiRules
+	if puzzle.Size != SamuraiSize || !gridsEqual(puzzle.Grids, samuraiGrids) {
+		lay, err := newMultiGridLayout(puzzle.Size, puzzle.Grids)
+		if err != nil {
+			return nil, err
+		}
+		base = newRules(lay, nil)
+	}
+	if lay := base.lay.withChessRules(puzzle.AntiKnight, puzzle.AntiKing); lay != base.lay {
+		base = newRules(lay, nil)
+	}
+	if len(puzzle.Constraints) == 0 {
+		return base, nil
+	}
+	constraints, err := constraintsFromModel(base.lay, puzzle.Constraints)
+	if err != nil {
+		return nil, err
+	}
+	return base.withConstraints(constraints...), nil
+}
+
+func gridsEqual(a, b []models.Grid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mustMultiGridLayout(width int, grids []models.Grid) *layout {
+	lay, err := newMultiGridLayout(width, grids)
+	if err != nil {
+		panic(err)
+	}
+	return lay
+}
+
+// sharedCells returns the position keys of the cells belonging to more
+// than one grid, i.e. to more than one row
+func (lay *layout) sharedCells() []string {
+	var shared []string
+	for cell, units := range lay.cellUnits {
+		rows := 0
+		for _, u := range units {
+			if lay.unitKinds[u] == unitRow {
+				rows++
+			}
+		}
+		if rows > 1 {
+			shared = append(shared, lay.key(cell))
+		}
+	}
+	return shared
+}
@@ -0,0 +1,151 @@
+package sudoku
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// MaxGridSize is the largest number of rows and columns a grid can have.
+// A PuzzleGrid always has room for this many; a puzzle's size says how many
+// of them it uses.
+const MaxGridSize = 25
+
+// ClassicSize is the size of a standard Sudoku, and of puzzles saved
+// before sizes were stored
+const ClassicSize = 9
+
+// boxShapes gives the rows and columns of a box for each supported size
+var boxShapes = map[int][2]int{
+	4:  {2, 2},
+	6:  {2, 3},
+	9:  {3, 3},
+	12: {3, 4},
+	16: {4, 4},
+	25: {5, 5},
+}
+
+// fewestClues is the fewest givens a puzzle with a unique solution can
+// have, for the sizes where it is known
+var fewestClues = map[int]int{4: 4, 6: 8, 9: 17}
+
+// sizeRules are the plain rules for every supported size. The 9x9 entry is
+// classicRules, so the selected backend is used for it.
+var sizeRules = buildSizeRules()
+
+func buildSizeRules() map[int]*rules {
+	all := make(map[int]*rules, len(boxShapes))
+	for size, shape := range boxShapes {
+		if size == ClassicSize {
+			all[size] = classicRules
+			continue
+		}
+		all[size] = newRules(newLayout(size, shape[0], shape[1]), nil)
+	}
+	return all
+}
+
+// ParseSize validates a grid size, defaulting to 9
+func ParseSize(sizeStr string) (int, error) {
+	if sizeStr == "" {
+		return ClassicSize, nil
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || boxShapes[size] == [2]int{} {
+		return 0, fmt.Errorf("unsupported grid size %q", sizeStr)
+	}
+	return size, nil
+}
+
+// ClueLimits returns the fewest and most givens a puzzle of the given size
+// with a unique solution can have. Where the fewest isn't known, the bound
+// is one less than the size, since two missing digits could be swapped.
+func ClueLimits(size int) (int, int) {
+	if fewest, ok := fewestClues[size]; ok {
+		return fewest, size * size
+	}
+	return size - 1, size * size
+}
+
+// puzzleSize returns the number of rows and columns of a puzzle
+func puzzleSize(puzzle models.Puzzle) int {
+	if puzzle.Size == 0 {
+		return ClassicSize
+	}
+	return puzzle.Size
+}
+
+// rulesForSize returns the plain rules of a size
+func rulesForSize(size int) (*rules, error) {
+	r, ok := sizeRules[size]
+	if !ok {
+		return nil, invalidPuzzle("unsupported grid size %d", size)
+	}
+	return r, nil
+}
+
+// Symbols returns the characters shown for the values 1..size: digits up to
+// 9x9, digits then letters up to 16x16, and letters only above that
+func Symbols(size int) string {
+	switch {
+	case size <= 9:
+		return "123456789"[:size]
+	case size <= 16:
+		return ("123456789" + "ABCDEFG")[:size]
+	default:
+		return "ABCDEFGHIJKLMNOPQRSTUVWXYZ"[:size]
+	}
+}
+
+// symbolsFor returns the symbols a puzzle of the given size stores, or ""
+// for sizes that just use digits
+func symbolsFor(size int) string {
+	if size <= 9 {
+		return ""
+	}
+	return Symbols(size)
+}
+
+// PrintGrid returns a string representation of a size x size grid for logging.
+// Multi-grid boards are drawn with the 3x3 boxes of their 9x9 grids.
+func PrintGrid(grid PuzzleGrid, size int) string {
+	shape, ok := boxShapes[size]
+	symbols := Symbols(size)
+	if !ok {
+		shape, symbols = boxShapes[ClassicSize], Symbols(ClassicSize)
+	}
+
+	var border strings.Builder
+	for col := 0; col < size; col++ {
+		if col%shape[1] == 0 {
+			border.WriteString("+-")
+		}
+		border.WriteString("--")
+	}
+	border.WriteString("+\n")
+
+	var output strings.Builder
+	for row := 0; row < size; row++ {
+		if row%shape[0] == 0 {
+			output.WriteString(border.String())
+		}
+		for col := 0; col < size; col++ {
+			if col%shape[1] == 0 {
+				output.WriteString("| ")
+			}
+
+			// Print the symbol or a dot if empty
+			if val := grid[row][col]; val >= 1 && val <= len(symbols) {
+				output.WriteByte(symbols[val-1])
+			} else {
+				output.WriteByte('.')
+			}
+			output.WriteByte(' ')
+		}
+		output.WriteString("|\n")
+	}
+	output.WriteString(border.String())
+	return output.String()
+}
@@ -0,0 +1,134 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+	"github.com/danjones/sudoku_dj/internal/utils"
+)
+
+// ErrMultipleSolutions is returned when a puzzle's system cells have more than one solution
+var ErrMultipleSolutions = errors.New("puzzle has more than one solution")
+
+// ErrInvalidCell is returned for a position key outside the grid
+var ErrInvalidCell = errors.New("invalid cell")
+
+// storeSolution records the solution grid on a puzzle, using the puzzle's size
+func storeSolution(puzzle *models.Puzzle, solution PuzzleGrid) {
+	size := puzzleSize(*puzzle)
+	puzzle.Solution = make([]int, 0, size*size)
+	for row := 0; row < size; row++ {
+		puzzle.Solution = append(puzzle.Solution, solution[row][:size]...)
+	}
+}
+
+// storedSolution returns the solution saved with a puzzle, if it has a
+// complete one that agrees with the puzzle's system cells. Board positions
+// without a cell, such as the gaps of a Samurai, are stored as 0.
+func storedSolution(puzzle models.Puzzle) (PuzzleGrid, bool) {
+	var grid PuzzleGrid
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return grid, false
+	}
+	lay := r.lay
+	if len(puzzle.Solution) != lay.width*lay.width {
+		return grid, false
+	}
+	for i, v := range puzzle.Solution {
+		row, col := i/lay.width, i%lay.width
+		if (lay.cellAt(row, col) < 0) != (v == 0) || v < 0 || v > lay.size {
+			return grid, false
+		}
+		grid[row][col] = v
+	}
+
+	givens := givensGrid(puzzle)
+	for row := range givens {
+		for col := range givens[row] {
+			if givens[row][col] != 0 && givens[row][col] != grid[row][col] {
+				return grid, false
+			}
+		}
+	}
+	return grid, true
+}
+
+// EnsureSolution fills in the stored solution of a puzzle saved before
+// solutions were stored, and reports whether the puzzle changed. A solution
+// is only stored if it is unique; ErrMultipleSolutions is returned otherwise.
+func EnsureSolution(ctx context.Context, puzzle *models.Puzzle, limits SearchLimits) (bool, error) {
+	if _, ok := storedSolution(*puzzle); ok {
+		return false, nil
+	}
+
+	r, err := rulesFor(*puzzle)
+	if err != nil {
+		return false, err
+	}
+
+	utils.Log(utils.LogLevelDebug, "Puzzle %s has no stored solution, solving system cells", puzzle.UUID)
+	limits.MaxSolutions = 2
+	solutions, err := r.enumerate(ctx, givensGrid(*puzzle), limits)
+	if err != nil {
+		return false, err
+	}
+
+	switch len(solutions) {
+	case 0:
+		return false, ErrNoSolution
+	case 1:
+		storeSolution(puzzle, solutions[0])
+		return true, nil
+	default:
+		utils.Log(utils.LogLevelWarn, "Puzzle %s has more than one solution, not storing one", puzzle.UUID)
+		return false, ErrMultipleSolutions
+	}
+}
+
+// solutionFor returns the stored solution of a puzzle. Puzzles without one
+// are solved, but only a unique solution is used.
+func solutionFor(ctx context.Context, puzzle models.Puzzle, limits SearchLimits) (PuzzleGrid, error) {
+	if _, err := EnsureSolution(ctx, &puzzle, limits); err != nil {
+		var grid PuzzleGrid
+		return grid, err
+	}
+	grid, _ := storedSolution(puzzle)
+	return grid, nil
+}
+
+// RevealSolution returns the row-major solution of a puzzle
+func RevealSolution(ctx context.Context, puzzle models.Puzzle, limits SearchLimits) ([]int, error) {
+	solution, err := solutionFor(ctx, puzzle, limits)
+	if err != nil {
+		return nil, err
+	}
+	storeSolution(&puzzle, solution)
+	return puzzle.Solution, nil
+}
+
+// RevealCell returns the solution value of the cell at posKey
+func RevealCell(ctx context.Context, puzzle models.Puzzle, posKey string, limits SearchLimits) (int, error) {
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return 0, err
+	}
+	cell := r.lay.cellOf(posKey)
+	if cell < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidCell, posKey)
+	}
+
+	solution, err := solutionFor(ctx, puzzle, limits)
+	if err != nil {
+		return 0, err
+	}
+	row, col := r.lay.rowCol(cell)
+	return solution[row][col], nil
+}
+
+// IsComplete reports whether every cell of a puzzle holds its solution value
+func IsComplete(puzzle models.Puzzle, solution PuzzleGrid) bool {
+	return cellsToGrid(puzzle.Cells, puzzleSize(puzzle)) == solution
+}
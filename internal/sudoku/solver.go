@@ -1,19 +1,12 @@
 package sudoku
 
-/*
-#cgo CFLAGS: -g -Wall
-#cgo LDFLAGS: -L../../c -lsudoku
-#include <stdio.h>
-#include <stdbool.h>
-#include "../../sudoku.h"
-*/
-import "C"
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
-	"strconv"
 	"time"
-	"unsafe"
 
 	"github.com/google/uuid"
 
@@ -21,91 +14,265 @@ import (
 	"github.com/danjones/sudoku_dj/internal/utils"
 )
 
-// PuzzleGrid represents a Sudoku grid
-type PuzzleGrid [9][9]C.int
+// PuzzleGrid represents a Sudoku grid. Grids smaller than MaxGridSize use
+// the top left corner and leave the other cells empty.
+type PuzzleGrid [MaxGridSize][MaxGridSize]int
+
+// generatorLimits bounds each uniqueness check made while removing clues.
+// A check that runs out of budget is treated as "not unique".
+var generatorLimits = SearchLimits{MaxNodes: 200000}
 
 // InitSolver initializes the sudoku solver
 func InitSolver() {
-	// Initialize random seed
-	rand.Seed(time.Now().UnixNano())
-	utils.Log(utils.LogLevelInfo, "Sudoku solver initialized")
+	utils.Log(utils.LogLevelInfo, "Sudoku solver initialized with %s backend", ActiveSolver().Name())
 }
 
-// PrintGrid returns a string representation of the grid for logging
-func PrintGrid(grid PuzzleGrid) string {
-	var output string
-
-	// Top border
-	output += "+-------+-------+-------+\n"
+// maxGenerateAttempts caps how many solution grids CreatePuzzle tries before
+// settling for the puzzle whose rating came closest to the requested band
+const maxGenerateAttempts = 200
+
+// largeAttemptCells sets how many solution grids CreatePuzzle tries above
+// 9x9: this many cells divided by the cells on the board, at least one. An
+// attempt there takes seconds, so this cap rather than the time budget
+// should end generation, keeping the seed reproducible.
+const largeAttemptCells = 768
+
+// maxSamuraiAttempts is how many solution grids CreatePuzzle tries for a
+// Samurai puzzle, for the same reason
+const maxSamuraiAttempts = 12
+
+// largeSolveRestarts is how many sets of random digits newSolutionGrid
+// tries to solve above 9x9, each within largeSolveLimits. A search there
+// either finishes quickly or wanders for a long time, so many small
+// searches find a solution grid sooner than one large one.
+const largeSolveRestarts = 20
+
+var largeSolveLimits = SearchLimits{MaxNodes: 2000}
+
+// classicCells is the number of cells on a classic 9x9 grid
+const classicCells = ClassicSize * ClassicSize
+
+// GeneratorVersion is bumped whenever a change to the generator means a seed
+// no longer produces the same puzzle as before
+const GeneratorVersion = 3
+
+// maxNoGivensAttempts is how many sets of cages CreatePuzzle tries for a
+// killer puzzle without givens
+const maxNoGivensAttempts = 20
+
+// defaultTargetTimeout bounds generation when a clue count or a minimal
+// puzzle is asked for, since those can take many attempts to meet, and for
+// grids above 9x9, where every uniqueness check is much slower
+const defaultTargetTimeout = 30 * time.Second
+
+// maxTrimSwaps caps how many clue swaps are tried on one solution grid to
+// bring a puzzle down to the maximum clue count
+const maxTrimSwaps = 30
+
+// ErrNoPuzzle is returned when no puzzle meeting the generation options was
+// found within the budget
+var ErrNoPuzzle = errors.New("no puzzle met the generation options within the budget")
+
+// GenerateOptions controls puzzle generation
+type GenerateOptions struct {
+	Level      int           // Difficulty level 0-9
+	Seed       int64         // Seed for the generator's random choices, 0 picks one at random
+	Symmetry   string        // Symmetry of the clue pattern, one of the Symmetry constants
+	Minimal    bool          // Every clue must be needed for a unique solution
+	MinClues   int           // Fewest givens allowed, 0 for no limit
+	MaxClues   int           // Most givens allowed, 0 for no limit
+	Mask       []bool        // Row-major positions the givens must take, nil for any
+	Technique  Technique     // Hardest technique the solve must need, overriding Level
+	Killer     bool          // Add killer cages
+	Jigsaw     bool          // Replace the boxes with random irregular regions
+	Regions    []string      // Extra regions, e.g. RegionDiagonal
+	NoGivens   bool          // With Killer, leave the grid empty so the cages alone give the solution
+	Size       int           // Rows and columns of the grid, 0 for 9
+	Samurai    bool          // Five overlapping 9x9 grids on a 21x21 board
+	AntiKnight bool          // No equal digits a knight's move apart
+	AntiKing   bool          // No equal digits a king's move apart
+	Timeout    time.Duration // Time budget for generation, 0 for the default
+}
 
-	for row := 0; row < 9; row++ {
-		output += "| "
-		for col := 0; col < 9; col++ {
-			// Print number or space if zero
-			val := grid[row][col]
-			if val == 0 {
-				output += ". "
-			} else {
-				output += fmt.Sprintf("%d ", val)
-			}
+// hasTarget reports whether the options ask for anything beyond a rating
+func (opts GenerateOptions) hasTarget() bool {
+	return opts.Minimal || opts.MinClues > 0 || opts.MaxClues > 0 || opts.Mask != nil || opts.Technique != "" || opts.NoGivens
+}
 
-			// Add vertical dividers
-			if col == 2 || col == 5 {
-				output += "| "
-			}
-		}
-		output += "|\n"
+// cluesInRange reports whether a clue count meets the options
+func (opts GenerateOptions) cluesInRange(clues int) bool {
+	return (opts.MinClues == 0 || clues >= opts.MinClues) && (opts.MaxClues == 0 || clues <= opts.MaxClues)
+}
 
-		// Add horizontal dividers
-		if row == 2 || row == 5 {
-			output += "+-------+-------+-------+\n"
+// NewSeed returns a random, non-zero generator seed
+func NewSeed() int64 {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		if seed := rng.Int63(); seed != 0 {
+			return seed
 		}
 	}
-
-	// Bottom border
-	output += "+-------+-------+-------+"
-	return output
 }
 
-// CreatePuzzle generates a new Sudoku puzzle with the specified difficulty level
-func CreatePuzzle(level int, logLevel string) models.Puzzle {
-	utils.Log(utils.LogLevelInfo, "Creating new puzzle with difficulty level %d", level)
-
-	var emptyGrid PuzzleGrid
-	initializeGrid(&emptyGrid)
-	placeRandomNumbers(&emptyGrid)
-
-	// Log the initial grid with random numbers
-	utils.Log(utils.LogLevelDebug, "Initial grid with random seeds:\n%s", PrintGrid(emptyGrid))
+// CreatePuzzle generates a new Sudoku puzzle with the specified difficulty level.
+// Candidate puzzles are rated by the techniques needed to solve them, and
+// generation continues until one falls in the rating band for the level.
+// Every random choice is drawn from the seed, so the same options, generator
+// version and solver backend always produce the same puzzle, as long as
+// generation isn't cut short by the context. Clue count and minimality are
+// hard requirements: ErrNoPuzzle is returned if they can't be met in time.
+// Killer puzzles get random cages, and can be asked to have no givens at all.
+// Extra regions such as diagonals hold every digit once, like boxes, and
+// jigsaw puzzles get random irregular regions in place of the boxes.
+// Samurai puzzles are made of five grids, and their givens must give a
+// unique solution across the whole board. The anti-knight and anti-king
+// rules can be added to any of them.
+func CreatePuzzle(ctx context.Context, opts GenerateOptions, logLevel string) (models.Puzzle, error) {
+	if opts.Seed == 0 {
+		opts.Seed = NewSeed()
+	}
+	if opts.Timeout == 0 && (opts.hasTarget() || opts.Size > ClassicSize || opts.Samurai) {
+		opts.Timeout = defaultTargetTimeout
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	level := opts.Level
+	utils.Log(utils.LogLevelInfo, "Creating new puzzle with difficulty level %d, seed %d and symmetry %q (killer %v, jigsaw %v, regions %v, samurai %v, anti-knight %v, anti-king %v)",
+		level, opts.Seed, opts.Symmetry, opts.Killer, opts.Jigsaw, opts.Regions, opts.Samurai, opts.AntiKnight, opts.AntiKing)
+	if opts.hasTarget() {
+		utils.Log(utils.LogLevelInfo, "Puzzle must have %d-%d clues (0 for no limit), minimal %v",
+			opts.MinClues, opts.MaxClues, opts.Minimal)
+	}
+	band := bandForLevel(level)
+	if opts.Technique != "" {
+		utils.Log(utils.LogLevelInfo, "Puzzle must need %s and nothing harder", opts.Technique)
+		band = bandForTechnique(opts.Technique)
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
 
-	// Convert to cells and attempt to solve
-	cells := gridToCells(emptyGrid)
-	utils.Log(utils.LogLevelDebug, "Attempting to solve initial grid")
-	solvedCells, solved := AttemptSolve(cells)
+	size := opts.Size
+	if size == 0 {
+		size = ClassicSize
+	}
+	base, err := rulesForSize(size)
+	if err != nil {
+		return models.Puzzle{}, err
+	}
+	if size != ClassicSize && (opts.Killer || opts.Jigsaw) {
+		return models.Puzzle{}, invalidPuzzle("killer and jigsaw puzzles need a 9x9 grid, not %dx%d", size, size)
+	}
+	lay, err := base.lay.withExtraRegions(opts.Regions)
+	if err != nil {
+		return models.Puzzle{}, err
+	}
+	if lay != base.lay {
+		base = newRules(lay, nil)
+	}
+	if opts.Samurai {
+		if size != ClassicSize || opts.Killer || opts.Jigsaw || lay.extraRegions != nil || opts.Mask != nil {
+			return models.Puzzle{}, invalidPuzzle("samurai puzzles are made of plain 9x9 grids")
+		}
+		base, lay = samuraiRules, samuraiRules.lay
+	}
+	if chess := lay.withChessRules(opts.AntiKnight, opts.AntiKing); chess != lay {
+		lay = chess
+		base = newRules(lay, nil)
+	}
 
-	if !solved {
-		utils.Log(utils.LogLevelError, "Failed to solve the initial grid")
-		return models.Puzzle{Cells: make(map[string]models.Cell)}
+	// With a mask the search for unique givens often stalls, so keep
+	// starting over from new solution grids until the time budget runs out,
+	// then allow the usual number of attempts to get closer to the rating
+	// band once one is found. Puzzles needing a technique are rare too, and
+	// only an exact match will do.
+	// Killer cages alone rarely give a puzzle the logical solver can finish,
+	// so few of them get near the band and there's no point trying for long.
+	// On boards larger than 9x9 each attempt is slow, so only a few are made.
+	maxAttempts := maxGenerateAttempts
+	if opts.Mask != nil || opts.Technique != "" {
+		maxAttempts = math.MaxInt
+	} else if opts.NoGivens {
+		maxAttempts = maxNoGivensAttempts
+	} else if opts.Samurai {
+		maxAttempts = maxSamuraiAttempts
+	} else if size > ClassicSize {
+		maxAttempts = largeAttemptCells / lay.numCells()
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
 	}
 
-	// Convert back to grid for refinement
-	solutionGrid := cellsToGrid(solvedCells)
+	var best candidate
+	bestDistance := math.Inf(1)
+	attempts := 0
+	for attempts < maxAttempts && bestDistance > 0 && ctx.Err() == nil {
+		attempts++
 
-	// Log the solved grid
-	utils.Log(utils.LogLevelDebug, "Solved grid:\n%s", PrintGrid(solutionGrid))
+		c, ok := makeCandidate(ctx, base, band, opts, rng)
+		if !ok {
+			continue
+		}
+		if opts.Mask != nil && math.IsInf(bestDistance, 1) && opts.Technique == "" {
+			utils.Log(utils.LogLevelDebug, "Found a unique puzzle for the mask after %d solution grids", attempts)
+			maxAttempts = attempts + maxGenerateAttempts
+		}
+		if distance := band.distance(c.rating); distance < bestDistance {
+			best, bestDistance = c, distance
+		}
+	}
+	bestGrid, bestRating := best.grid, best.rating
 
-	utils.Log(utils.LogLevelDebug, "Refining puzzle to difficulty level %d", level)
-	refinedGrid := refinePuzzle(solutionGrid, level)
+	if math.IsInf(bestDistance, 1) {
+		utils.Log(utils.LogLevelError, "Failed to generate a puzzle after %d attempts", attempts)
+		return models.Puzzle{}, ErrNoPuzzle
+	}
+	if bestDistance > 0 && opts.Technique != "" {
+		utils.Log(utils.LogLevelError, "No puzzle needing %s found after %d attempts, closest needs %q",
+			opts.Technique, attempts, bestRating.Hardest)
+		return models.Puzzle{}, ErrNoPuzzle
+	}
+	if bestDistance > 0 {
+		utils.Log(utils.LogLevelWarn, "No puzzle in the rating band for level %d after %d attempts, using closest (%.1f, %s)",
+			level, attempts, bestRating.Score, bestRating.Tier)
+	}
 
 	// Log the final grid with cells removed
-	utils.Log(utils.LogLevelDebug, "Final puzzle grid (with cells removed):\n%s", PrintGrid(refinedGrid))
+	utils.Log(utils.LogLevelDebug, "Final puzzle grid (with cells removed):\n%s", PrintGrid(bestGrid, lay.width))
 
 	// Create puzzle with system cells marked
 	puzzle := models.Puzzle{
 		UUID:       uuid.New().String(),
-		Cells:      gridToCells(refinedGrid),
+		Cells:      gridToCells(bestGrid, lay),
 		CreatedAt:  time.Now().Format(time.RFC3339),
 		Difficulty: level,
+		Seed:       opts.Seed,
+		Version:    GeneratorVersion,
+		Symmetry:   opts.Symmetry,
+	}
+	if size != ClassicSize {
+		puzzle.Size = size
+		puzzle.Symbols = symbolsFor(size)
+	}
+	if opts.Samurai {
+		puzzle.Size = SamuraiSize
+		puzzle.Grids = SamuraiGrids()
+		puzzle.SharedCells = lay.sharedCells()
+	}
+	puzzle.AntiKnight, puzzle.AntiKing = lay.antiKnight, lay.antiKing
+	ApplyRating(&puzzle, bestRating)
+	storeSolution(&puzzle, best.solution)
+	if best.cages != nil {
+		puzzle.Cages = cagesToModel(best.cages)
+	}
+	puzzle.ExtraRegions = lay.extraRegions
+	puzzle.RegionMap = best.regionMap
+	if opts.Technique != "" {
+		puzzle.Difficulty = LevelForRating(bestRating)
+		puzzle.Technique = string(opts.Technique)
+	} else {
+		puzzle.OffBand = bestDistance > 0
 	}
 
 	// Mark system-generated cells
@@ -117,69 +284,204 @@ func CreatePuzzle(level int, logLevel string) models.Puzzle {
 			nonEmptyCells++
 		}
 	}
+	puzzle.Signature = Signature(puzzle)
+
+	utils.Log(utils.LogLevelInfo, "Created puzzle with %d filled cells, rated %.1f (%s) after %d attempts",
+		nonEmptyCells, bestRating.Score, bestRating.Tier, attempts)
+	return puzzle, nil
+}
 
-	utils.Log(utils.LogLevelInfo, "Created puzzle with %d filled cells", nonEmptyCells)
-	return puzzle
+// candidate is one puzzle tried by CreatePuzzle
+type candidate struct {
+	grid      PuzzleGrid // Givens
+	solution  PuzzleGrid
+	rating    Rating
+	cages     []*cage
+	regionMap []int
 }
 
-// AttemptSolve attempts to solve a Sudoku puzzle
-func AttemptSolve(cells map[string]models.Cell) (map[string]models.Cell, bool) {
+// makeCandidate builds one puzzle from a new solution grid and reports
+// whether it has a unique solution and meets the hard requirements in opts
+func makeCandidate(ctx context.Context, base *rules, band difficultyBand, opts GenerateOptions, rng *rand.Rand) (candidate, bool) {
+	var c candidate
+	r := base
+	if opts.Jigsaw {
+		c.regionMap = makeJigsawRegions(rng)
+		lay, err := newJigsawLayout(c.regionMap)
+		if err == nil {
+			lay, err = lay.withExtraRegions(base.lay.extraRegions)
+		}
+		if err == nil {
+			lay = lay.withChessRules(base.lay.antiKnight, base.lay.antiKing)
+		}
+		if err != nil {
+			utils.Log(utils.LogLevelError, "Made an invalid jigsaw layout: %v", err)
+			return c, false
+		}
+		r = newRules(lay, nil)
+	}
+
+	var ok bool
+	if c.solution, ok = newSolutionGrid(ctx, r, rng); !ok {
+		return c, false
+	}
+
+	if opts.Killer {
+		c.cages = makeCages(c.solution, rng)
+		cages := make([]Constraint, len(c.cages))
+		for i, cg := range c.cages {
+			cages[i] = cg
+		}
+		r = r.withConstraints(cages...)
+		utils.Log(utils.LogLevelDebug, "Made %d killer cages", len(c.cages))
+	}
+
+	switch {
+	case opts.Killer && opts.NoGivens:
+		if uniqueness, err := r.checkUniqueness(ctx, c.grid, generatorLimits); err != nil || uniqueness != UniqueSolution {
+			utils.Log(utils.LogLevelDebug, "Cages alone don't give a unique solution (%v)", err)
+			return c, false
+		}
+		c.rating = rateGrid(ctx, r, c.grid)
+		return c, true
+	case opts.Mask != nil:
+		c.grid, c.solution, c.rating, ok = searchMask(ctx, r, c.solution, opts.Mask, rng)
+		return c, ok
+	default:
+		utils.Log(utils.LogLevelDebug, "Refining puzzle to difficulty level %d", opts.Level)
+		c.grid, c.rating, ok = refinePuzzle(ctx, r, c.solution, band, opts, rng)
+		if !ok {
+			utils.Log(utils.LogLevelDebug, "Puzzle with %d clues doesn't meet the clue target", countClues(c.grid))
+		}
+		return c, ok
+	}
+}
+
+// newSolutionGrid builds a random completely filled grid
+func newSolutionGrid(ctx context.Context, r *rules, rng *rand.Rand) (PuzzleGrid, bool) {
+	if r.lay.regionMap != nil {
+		return newJigsawSolutionGrid(ctx, r, rng)
+	}
+
+	restarts, limits := 1, SearchLimits{}
+	if r.lay.size > ClassicSize {
+		restarts, limits = largeSolveRestarts, largeSolveLimits
+	} else if r != classicRules {
+		// Random digits can clash with variant rules in ways that take a
+		// long search to prove, so give up early and try again
+		limits = generatorLimits
+	}
+
+	var solutionGrid PuzzleGrid
+	solved := false
+	for try := 0; try < restarts && !solved; try++ {
+		// On a multi-grid board the random digits go in the top left 9x9
+		// corner, which is the first grid of a Samurai
+		var emptyGrid PuzzleGrid
+		initializeGrid(&emptyGrid)
+		placeRandomNumbers(&emptyGrid, r.lay.size, rng)
+
+		// Log the initial grid with random numbers
+		utils.Log(utils.LogLevelDebug, "Initial grid with random seeds:\n%s", PrintGrid(emptyGrid, r.lay.width))
+
+		// Attempt to solve
+		utils.Log(utils.LogLevelDebug, "Attempting to solve initial grid")
+		solutionGrid = emptyGrid
+		var err error
+		solved, err = r.solver().Solve(ctx, &solutionGrid, limits)
+
+		if !solved {
+			// Random digits that clash are expected now and then, so this is
+			// only worth a trace; err is nil when the search simply finds nothing
+			if err != nil {
+				utils.Log(utils.LogLevelTrace, "Gave up solving the initial grid: %v", err)
+			} else {
+				utils.Log(utils.LogLevelTrace, "Initial grid has no solution, trying again")
+			}
+		}
+	}
+	if !solved {
+		return PuzzleGrid{}, false
+	}
+
+	// Log the solved grid
+	utils.Log(utils.LogLevelDebug, "Solved grid:\n%s", PrintGrid(solutionGrid, r.lay.width))
+	return solutionGrid, true
+}
+
+// newJigsawSolutionGrid builds a random solution grid for irregular regions.
+// Random starting digits often clash with the regions in ways that take a
+// long search to prove, so the empty grid is solved and its digits relabelled
+// at random instead. Some region layouts have no solution at all.
+func newJigsawSolutionGrid(ctx context.Context, r *rules, rng *rand.Rand) (PuzzleGrid, bool) {
+	var solutionGrid PuzzleGrid
+	solved, err := r.solver().Solve(ctx, &solutionGrid, generatorLimits)
+	if !solved {
+		utils.Log(utils.LogLevelDebug, "No solution found for the jigsaw regions: %v", err)
+		return PuzzleGrid{}, false
+	}
+
+	size := r.lay.size
+	relabel := rng.Perm(size)
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			solutionGrid[row][col] = relabel[solutionGrid[row][col]-1] + 1
+		}
+	}
+	utils.Log(utils.LogLevelDebug, "Solved grid:\n%s", PrintGrid(solutionGrid, size))
+	return solutionGrid, true
+}
+
+// AttemptSolve attempts to solve a puzzle's filled cells within the given
+// limits, under the puzzle's size and variant rules
+func AttemptSolve(ctx context.Context, puzzle models.Puzzle, limits SearchLimits) (map[string]models.Cell, bool, error) {
 	utils.Log(utils.LogLevelDebug, "Attempting to solve puzzle")
 	startTime := time.Now()
 
-	grid := cellsToGrid(cells)
-	solved := C.solve_sudoku((*C.int)(unsafe.Pointer(&grid[0][0])))
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return puzzle.Cells, false, err
+	}
+	grid := cellsToGrid(puzzle.Cells, r.lay.width)
+	solved, err := r.solver().Solve(ctx, &grid, limits)
 
 	duration := time.Since(startTime)
-	if bool(solved) {
+	if solved {
 		utils.Log(utils.LogLevelInfo, "Puzzle solved successfully in %v", duration)
-		return gridToCells(grid), true
+		return gridToCells(grid, r.lay), true, nil
 	}
 
 	utils.Log(utils.LogLevelWarn, "Failed to solve puzzle after %v", duration)
-	return cells, false
+	return puzzle.Cells, false, err
 }
 
-// ValidateSolution validates user-entered cells against a solution
-func ValidateSolution(puzzle models.Puzzle) (models.Puzzle, bool) {
+// ValidateSolution validates user-entered cells against the stored solution.
+// Puzzles saved without one are solved first; an error is returned if the
+// solver runs out of budget or the system cells don't have a unique solution.
+func ValidateSolution(ctx context.Context, puzzle models.Puzzle, limits SearchLimits) (models.Puzzle, bool, error) {
 	utils.Log(utils.LogLevelDebug, "Validating puzzle solution")
 
-	// Create a grid with only system cells
-	var systemGrid PuzzleGrid
-	systemCellCount := 0
-	for posKey, cell := range puzzle.Cells {
-		if cell.Status == "s" {
-			pos, _ := strconv.Atoi(posKey)
-			row, col := (pos-1)/9, (pos-1)%9
-			systemGrid[row][col] = C.int(cell.Value)
-			systemCellCount++
-		}
+	r, err := rulesFor(puzzle)
+	if err != nil {
+		return puzzle, false, err
 	}
 
-	utils.Log(utils.LogLevelDebug, "System cells grid:\n%s", PrintGrid(systemGrid))
-	utils.Log(utils.LogLevelDebug, "Solving grid with %d system cells", systemCellCount)
-
-	// Get the solution using only system cells
 	startTime := time.Now()
-	solved := C.solve_sudoku((*C.int)(unsafe.Pointer(&systemGrid[0][0])))
+	systemGrid, err := solutionFor(ctx, puzzle, limits)
 	duration := time.Since(startTime)
 
-	if !bool(solved) {
-		utils.Log(utils.LogLevelWarn, "Failed to solve with system cells after %v", duration)
-		return puzzle, false
+	if err != nil {
+		utils.Log(utils.LogLevelWarn, "No solution to validate against after %v: %v", duration, err)
+		return puzzle, false, err
 	}
 
-	utils.Log(utils.LogLevelDebug, "Solved grid:\n%s", PrintGrid(systemGrid))
-	utils.Log(utils.LogLevelDebug, "Solved with system cells in %v", duration)
+	lay := r.lay
+	utils.Log(utils.LogLevelDebug, "Solution grid:\n%s", PrintGrid(systemGrid, lay.width))
+	utils.Log(utils.LogLevelDebug, "Got solution in %v", duration)
 
 	// Create a grid with user's solution for display
-	var userGrid PuzzleGrid
-	for posKey, cell := range puzzle.Cells {
-		pos, _ := strconv.Atoi(posKey)
-		row, col := (pos-1)/9, (pos-1)%9
-		userGrid[row][col] = C.int(cell.Value)
-	}
-	utils.Log(utils.LogLevelDebug, "User's solution grid:\n%s", PrintGrid(userGrid))
+	userGrid := cellsToGrid(puzzle.Cells, lay.width)
+	utils.Log(utils.LogLevelDebug, "User's solution grid:\n%s", PrintGrid(userGrid, lay.width))
 
 	// Validate each user-entered cell against the solution
 	correctCount := 0
@@ -191,9 +493,11 @@ func ValidateSolution(puzzle models.Puzzle) (models.Puzzle, bool) {
 		}
 
 		// Check if this cell's value matches the solution
-		pos, _ := strconv.Atoi(posKey)
-		row, col := (pos-1)/9, (pos-1)%9
-		if systemGrid[row][col] == C.int(cell.Value) {
+		pos := lay.cellOf(posKey)
+		if pos < 0 {
+			continue
+		}
+		if row, col := lay.rowCol(pos); systemGrid[row][col] == cell.Value {
 			cell.Status = "c" // Correct
 			correctCount++
 		} else {
@@ -203,8 +507,17 @@ func ValidateSolution(puzzle models.Puzzle) (models.Puzzle, bool) {
 		puzzle.Cells[posKey] = cell
 	}
 
-	utils.Log(utils.LogLevelInfo, "Validation complete: %d correct, %d wrong cells", correctCount, wrongCount)
-	return puzzle, true
+	// Cells matching the unique solution can't break a cage, but check the
+	// sums anyway so a bad stored solution can't mark a puzzle completed
+	broken := r.broken(userGrid)
+	for _, c := range broken {
+		utils.Log(utils.LogLevelDebug, "Grid breaks %s", r.constraints[c].Describe(r.lay.cellName))
+	}
+
+	puzzle.Completed = len(broken) == 0 && IsComplete(puzzle, systemGrid)
+	utils.Log(utils.LogLevelInfo, "Validation complete: %d correct, %d wrong cells, %d broken constraints, completed %v",
+		correctCount, wrongCount, len(broken), puzzle.Completed)
+	return puzzle, true, nil
 }
 
 // initializeGrid initializes a grid with zeros
@@ -217,115 +530,251 @@ func initializeGrid(grid *PuzzleGrid) {
 	}
 }
 
-// placeRandomNumbers places random numbers in the grid
-func placeRandomNumbers(grid *PuzzleGrid) {
+// placeRandomNumbers places each value once at random in a size x size grid
+func placeRandomNumbers(grid *PuzzleGrid, size int, rng *rand.Rand) {
 	utils.Log(utils.LogLevelDebug, "Placing initial random numbers")
-	for num := 1; num <= 9; num++ {
-		x, y := rand.Intn(9), rand.Intn(9)
+	for num := 1; num <= size; num++ {
+		x, y := rng.Intn(size), rng.Intn(size)
 		for grid[x][y] != 0 {
-			x, y = rand.Intn(9), rand.Intn(9)
+			x, y = rng.Intn(size), rng.Intn(size)
 		}
-		grid[x][y] = C.int(num)
+		grid[x][y] = num
 		utils.Log(utils.LogLevelTrace, "Placed %d at position (%d,%d)", num, x, y)
 	}
 }
 
-// refinePuzzle refines a solved puzzle to create a playable puzzle
-func refinePuzzle(grid PuzzleGrid, difficulty int) PuzzleGrid {
-	utils.Log(utils.LogLevelDebug, "Refining puzzle to achieve difficulty level %d", difficulty)
+// refinePuzzle refines a solved puzzle to create a playable puzzle. Clues are
+// removed while the solution stays unique, then added back one at a time
+// until the puzzle is no harder than the requested band. Clues are removed
+// and added back a whole symmetry orbit at a time. It reports whether the
+// puzzle meets the clue count and minimality asked for in opts.
+func refinePuzzle(ctx context.Context, r *rules, grid PuzzleGrid, band difficultyBand, opts GenerateOptions, rng *rand.Rand) (PuzzleGrid, Rating, bool) {
+	utils.Log(utils.LogLevelDebug, "Refining puzzle to a rating between %.1f and %.1f with symmetry %q",
+		band.minScore, band.maxScore, opts.Symmetry)
 
 	var refinedGrid = grid
-	attempts := 0
-	removedCount := 0
 
-	// Create a list of all positions to check in random order
-	positions := make([][2]int, 81)
-	for i := 0; i < 81; i++ {
-		positions[i] = [2]int{i / 9, i % 9}
+	// Try to remove each orbit once, in random order. Since removing clues
+	// never makes a solution unique again, this leaves every remaining orbit
+	// needed, unless a uniqueness check ran out of budget.
+	orbits := shuffledOrbits(opts.Symmetry, r.lay, rng)
+	removedCount, minimal := removeOrbits(ctx, r, &refinedGrid, grid, orbits)
+	utils.Log(utils.LogLevelDebug, "Initial refinement complete after %d attempts with %d cells removed", len(orbits), removedCount)
+
+	// Swap clues around until there are few enough
+	if opts.MaxClues > 0 && countClues(refinedGrid) > opts.MaxClues {
+		minimal = trimClues(ctx, r, &refinedGrid, grid, opts, rng, minimal)
 	}
 
-	// This creates a random permutation of all cell positions
-	for i := 0; i < len(positions); i++ {
-		j := i + rand.Intn(len(positions)-i)
-		positions[i], positions[j] = positions[j], positions[i]
+	// Add values back while the puzzle is harder than the band allows, or
+	// has too few clues. A minimal puzzle can't have any added back. When a
+	// technique is asked for, clues that would make the puzzle too easy are
+	// skipped rather than added.
+	rating := rateGrid(ctx, r, refinedGrid)
+	addedBack := 0
+	if !opts.Minimal {
+		clues := countClues(refinedGrid)
+		for _, orbit := range shuffledOrbits(opts.Symmetry, r.lay, rng) {
+			if ctx.Err() != nil {
+				return refinedGrid, rating, false
+			}
+			if band.compare(rating) <= 0 {
+				break
+			}
+			if refinedGrid[orbit[0][0]][orbit[0][1]] != 0 || (opts.MaxClues > 0 && clues+len(orbit) > opts.MaxClues) {
+				continue
+			}
+			restoreOrbit(&refinedGrid, grid, orbit)
+			added := rateGrid(ctx, r, refinedGrid)
+			if opts.Technique != "" && band.compare(added) < 0 {
+				for _, pos := range orbit {
+					refinedGrid[pos[0]][pos[1]] = 0
+				}
+				continue
+			}
+			rating = added
+			clues += len(orbit)
+			addedBack += len(orbit)
+			utils.Log(utils.LogLevelTrace, "Added back %d cells at (%d,%d)", len(orbit), orbit[0][0], orbit[0][1])
+		}
+
+		if opts.MinClues > 0 && clues < opts.MinClues {
+			for _, orbit := range shuffledOrbits(opts.Symmetry, r.lay, rng) {
+				if ctx.Err() != nil {
+					return refinedGrid, rating, false
+				}
+				if clues >= opts.MinClues {
+					break
+				}
+				if refinedGrid[orbit[0][0]][orbit[0][1]] != 0 || (opts.MaxClues > 0 && clues+len(orbit) > opts.MaxClues) {
+					continue
+				}
+				restoreOrbit(&refinedGrid, grid, orbit)
+				clues += len(orbit)
+				addedBack += len(orbit)
+			}
+			rating = rateGrid(ctx, r, refinedGrid)
+		}
 	}
 
-	// Try to remove each cell once
-	for _, pos := range positions {
-		x, y := pos[0], pos[1]
-		attempts++
+	utils.Log(utils.LogLevelDebug, "Added back %d values, puzzle rated %.1f (%s) with effort %.1f",
+		addedBack, rating.Score, rating.Tier, rating.Effort)
 
-		// Skip already empty cells
-		if refinedGrid[x][y] == 0 {
+	// A rating cut short by the context isn't a real one
+	ok := ctx.Err() == nil && opts.cluesInRange(countClues(refinedGrid)) && (!opts.Minimal || minimal)
+	return refinedGrid, rating, ok
+}
+
+// removeOrbits tries to remove each orbit from the grid in turn, keeping the
+// removal if the solution stays unique. It returns the number of cells
+// removed and whether every kept orbit was proven to be needed.
+func removeOrbits(ctx context.Context, r *rules, refinedGrid *PuzzleGrid, grid PuzzleGrid, orbits [][][2]int) (int, bool) {
+	removedCount := 0
+	proven := true
+	limits := removalLimits(r.lay)
+	for attempts, orbit := range orbits {
+		// Skip already empty orbits
+		if refinedGrid[orbit[0][0]][orbit[0][1]] == 0 {
 			continue
 		}
 
-		removedValue := refinedGrid[x][y]
-		refinedGrid[x][y] = 0
+		for _, pos := range orbit {
+			refinedGrid[pos[0]][pos[1]] = 0
+		}
 
 		// Check if removal maintains a unique solution
-		if newCnt := int(C.count_solutions((*C.int)(unsafe.Pointer(&refinedGrid[0][0])))); newCnt > 1 {
-			// Restore value if it creates multiple solutions
-			refinedGrid[x][y] = removedValue
-			utils.Log(utils.LogLevelTrace, "Removing cell (%d,%d) would create multiple solutions, restoring", x, y)
+		if uniqueness, err := r.checkUniqueness(ctx, *refinedGrid, limits); err != nil || uniqueness != UniqueSolution {
+			// Restore values if it creates multiple solutions or can't be proven unique
+			restoreOrbit(refinedGrid, grid, orbit)
+			if err != nil {
+				proven = false
+			}
+			utils.Log(utils.LogLevelTrace, "Removing %d cells at (%d,%d) would create multiple solutions, restoring (%v)",
+				len(orbit), orbit[0][0], orbit[0][1], err)
 		} else {
 			// Successful removal
-			removedCount++
-			utils.Log(utils.LogLevelTrace, "Removed value %d at position (%d,%d)", removedValue, x, y)
+			removedCount += len(orbit)
+			utils.Log(utils.LogLevelTrace, "Removed %d cells at (%d,%d)", len(orbit), orbit[0][0], orbit[0][1])
 		}
 
 		// Log every 10th attempt to show progress with current grid state
-		if attempts%10 == 0 {
+		if (attempts+1)%10 == 0 {
 			utils.Log(utils.LogLevelTrace, "Current grid after %d removal attempts, %d removals:\n%s",
-				attempts, removedCount, PrintGrid(refinedGrid))
+				attempts+1, removedCount, PrintGrid(*refinedGrid, r.lay.width))
 		}
 	}
+	return removedCount, proven
+}
 
-	utils.Log(utils.LogLevelDebug, "Initial refinement complete after %d attempts with %d cells removed", attempts, removedCount)
-
-	// Add some values back based on difficulty
-	addedBack := 0
-	for i := 9 - difficulty; i >= 0; i-- {
-		x, y := rand.Intn(9), rand.Intn(9)
-		for refinedGrid[x][y] != 0 {
-			x, y = rand.Intn(9), rand.Intn(9)
+// trimClues lowers the clue count of a puzzle whose every clue is needed by
+// swapping: one removed orbit is given again, then every other clue is tried
+// for removal. The swap is kept if it leaves fewer clues. It reports whether
+// the kept clues were all proven to be needed, given whether they were before.
+func trimClues(ctx context.Context, r *rules, refinedGrid *PuzzleGrid, grid PuzzleGrid, opts GenerateOptions, rng *rand.Rand, proven bool) bool {
+	clues := countClues(*refinedGrid)
+	for swaps := 0; swaps < maxTrimSwaps && clues > opts.MaxClues && ctx.Err() == nil; swaps++ {
+		orbits := shuffledOrbits(opts.Symmetry, r.lay, rng)
+
+		// Give one removed orbit again and try removing it last, since the
+		// other removals may make it unneeded
+		added := -1
+		for i, orbit := range orbits {
+			if refinedGrid[orbit[0][0]][orbit[0][1]] == 0 {
+				added = i
+				break
+			}
+		}
+		if added < 0 {
+			break
 		}
-		refinedGrid[x][y] = grid[x][y]
-		addedBack++
-		utils.Log(utils.LogLevelTrace, "Added back value %d at position (%d,%d)", grid[x][y], x, y)
+		orbits = append(append(orbits[:added:added], orbits[added+1:]...), orbits[added])
+
+		candidate := *refinedGrid
+		restoreOrbit(&candidate, grid, orbits[len(orbits)-1])
+		_, candidateProven := removeOrbits(ctx, r, &candidate, grid, orbits)
+		if candidateClues := countClues(candidate); candidateClues < clues {
+			utils.Log(utils.LogLevelTrace, "Clue swap %d brought the puzzle from %d to %d clues", swaps+1, clues, candidateClues)
+			*refinedGrid, clues, proven = candidate, candidateClues, candidateProven
+		}
+	}
+	return proven
+}
+
+// removalLimits bounds each uniqueness check made while removing clues. On
+// boards larger than 9x9 there are more checks and each search node costs
+// more, both growing with the number of cells, so the budget shrinks with
+// its square to keep an attempt to seconds.
+func removalLimits(lay *layout) SearchLimits {
+	limits := generatorLimits
+	if cells := int64(lay.numCells()); cells > classicCells {
+		limits.MaxNodes = limits.MaxNodes * classicCells * classicCells / (cells * cells)
 	}
+	return limits
+}
+
+// restoreOrbit puts the solution values of an orbit back into a grid
+func restoreOrbit(refinedGrid *PuzzleGrid, grid PuzzleGrid, orbit [][2]int) {
+	for _, pos := range orbit {
+		refinedGrid[pos[0]][pos[1]] = grid[pos[0]][pos[1]]
+	}
+}
 
-	utils.Log(utils.LogLevelDebug, "Added back %d values based on difficulty level", addedBack)
+// givensGrid returns a grid holding only the system cells of a puzzle
+func givensGrid(puzzle models.Puzzle) PuzzleGrid {
+	size := puzzleSize(puzzle)
+	var grid PuzzleGrid
+	for posKey, cell := range puzzle.Cells {
+		if cell.Status == "s" {
+			if pos := cellIndex(posKey); pos >= 0 && pos < size*size {
+				grid[pos/size][pos%size] = cell.Value
+			}
+		}
+	}
+	return grid
+}
 
-	return refinedGrid
+// countClues returns the number of filled cells in a grid
+func countClues(grid PuzzleGrid) int {
+	count := 0
+	for row := range grid {
+		for col := range grid[row] {
+			if grid[row][col] != 0 {
+				count++
+			}
+		}
+	}
+	return count
 }
 
-// gridToCells converts a PuzzleGrid to a map of cells
-func gridToCells(grid PuzzleGrid) map[string]models.Cell {
+// gridToCells converts a PuzzleGrid to a map of the cells of a layout
+func gridToCells(grid PuzzleGrid, lay *layout) map[string]models.Cell {
 	utils.Log(utils.LogLevelTrace, "Converting grid to cells")
 	cells := make(map[string]models.Cell)
-	for row := 0; row < 9; row++ {
-		for col := 0; col < 9; col++ {
-			pos := row*9 + col + 1
-			posKey := fmt.Sprintf("%02d", pos)
-			cells[posKey] = models.Cell{
-				Value:  int(grid[row][col]),
-				Notes:  []int{},
-				Status: "", // Will be set to "s" for system-generated cells
-			}
+	for cell := 0; cell < lay.numCells(); cell++ {
+		row, col := lay.rowCol(cell)
+		cells[lay.key(cell)] = models.Cell{
+			Value:  grid[row][col],
+			Notes:  []int{},
+			Status: "", // Will be set to "s" for system-generated cells
 		}
 	}
 	return cells
 }
 
-// cellsToGrid converts a map of cells to a PuzzleGrid
-func cellsToGrid(cells map[string]models.Cell) PuzzleGrid {
+// cellKey returns the position key (01-81 on a 9x9 grid) used in models.Puzzle for a 0-based cell index
+func cellKey(cell int) string {
+	return fmt.Sprintf("%02d", cell+1)
+}
+
+// cellsToGrid converts a map of cells to a PuzzleGrid for a board with
+// width rows and columns
+func cellsToGrid(cells map[string]models.Cell, width int) PuzzleGrid {
 	utils.Log(utils.LogLevelTrace, "Converting cells to grid")
 	var grid PuzzleGrid
 	for posKey, cell := range cells {
-		pos, _ := strconv.Atoi(posKey)
-		row, col := (pos-1)/9, (pos-1)%9
-		grid[row][col] = C.int(cell.Value)
+		if pos := cellIndex(posKey); pos >= 0 && pos < width*width {
+			grid[pos/width][pos%width] = cell.Value
+		}
 	}
 	return grid
 }
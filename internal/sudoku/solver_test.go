@@ -0,0 +1,184 @@
+package sudoku
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/danjones/sudoku_dj/internal/models"
+)
+
+// parseGrid reads a row-major grid of width x width digits, "0" or "." for
+// an empty cell
+func parseGrid(t *testing.T, s string, width int) PuzzleGrid {
+	t.Helper()
+	if len(s) != width*width {
+		t.Fatalf("grid has %d cells, want %d", len(s), width*width)
+	}
+	var grid PuzzleGrid
+	for i, ch := range s {
+		if ch != '.' && ch != '0' {
+			grid[i/width][i%width] = int(ch - '0')
+		}
+	}
+	return grid
+}
+
+// givensPuzzle makes a puzzle whose filled cells are all system cells
+func givensPuzzle(grid PuzzleGrid, lay *layout) models.Puzzle {
+	puzzle := models.Puzzle{UUID: "test", Cells: gridToCells(grid, lay)}
+	for posKey, cell := range puzzle.Cells {
+		if cell.Value != 0 {
+			cell.Status = "s"
+			puzzle.Cells[posKey] = cell
+		}
+	}
+	return puzzle
+}
+
+// addEntries fills cells of a puzzle with user entries, by position key
+func addEntries(puzzle models.Puzzle, entries map[string]int) {
+	for posKey, value := range entries {
+		puzzle.Cells[posKey] = models.Cell{Value: value, Notes: []int{}, Status: "u"}
+	}
+}
+
+// classicPuzzle is a puzzle with a unique solution, shared by the tests
+const (
+	classicPuzzle   = "530070000600195000098000060800060003400803001700020006060000280000419005000080079"
+	classicSolution = "534678912672195348198342567859761423426853791713924856961537284287419635345286179"
+)
+
+func TestCreatePuzzleIsReproducible(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GenerateOptions
+	}{
+		{"classic", GenerateOptions{Level: 3, Seed: 42}},
+		{"symmetric", GenerateOptions{Level: 2, Seed: 7, Symmetry: SymmetryRotational}},
+		{"killer", GenerateOptions{Level: 2, Seed: 11, Killer: true}},
+		{"jigsaw", GenerateOptions{Level: 2, Seed: 13, Jigsaw: true}},
+		{"technique", GenerateOptions{Seed: 17, Technique: TechniquePointing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := CreatePuzzle(context.Background(), tt.opts, "")
+			if err != nil {
+				t.Fatalf("CreatePuzzle: %v", err)
+			}
+			second, err := CreatePuzzle(context.Background(), tt.opts, "")
+			if err != nil {
+				t.Fatalf("CreatePuzzle again: %v", err)
+			}
+
+			if first.Seed != tt.opts.Seed || first.Version != GeneratorVersion {
+				t.Errorf("seed %d version %d, want %d and %d", first.Seed, first.Version, tt.opts.Seed, GeneratorVersion)
+			}
+			if first.UUID == second.UUID {
+				t.Errorf("both puzzles have UUID %s", first.UUID)
+			}
+			if !reflect.DeepEqual(first.Cells, second.Cells) {
+				t.Errorf("cells differ between runs")
+			}
+			if !reflect.DeepEqual(first.Solution, second.Solution) || first.Rating != second.Rating ||
+				!reflect.DeepEqual(first.Cages, second.Cages) || !reflect.DeepEqual(first.RegionMap, second.RegionMap) {
+				t.Errorf("solution, rating, cages or regions differ between runs")
+			}
+		})
+	}
+}
+
+func TestCreatePuzzleMeetsOptions(t *testing.T) {
+	puzzle, err := CreatePuzzle(context.Background(), GenerateOptions{Seed: 17, Technique: TechniquePointing}, "")
+	if err != nil {
+		t.Fatalf("CreatePuzzle: %v", err)
+	}
+	if puzzle.Technique != string(TechniquePointing) || puzzle.Rating != techniqueScores[TechniquePointing] {
+		t.Errorf("technique %q rated %.1f, want %q rated %.1f",
+			puzzle.Technique, puzzle.Rating, TechniquePointing, techniqueScores[TechniquePointing])
+	}
+	uniqueness, err := CheckUniqueness(context.Background(), givensGrid(puzzle), SearchLimits{})
+	if err != nil || uniqueness != UniqueSolution {
+		t.Errorf("givens have %v solutions (%v), want a unique one", uniqueness, err)
+	}
+}
+
+func TestValidateSolution(t *testing.T) {
+	solution := parseGrid(t, classicSolution, 9)
+	tests := []struct {
+		name          string
+		entries       map[string]int // User entries by position key, nil to fill every cell
+		unsolved      bool           // Don't store the solution, so it is solved for
+		wantStatus    map[string]string
+		wantCompleted bool
+	}{
+		{
+			name:       "correct and wrong entries",
+			entries:    map[string]int{"03": 4, "04": 5},
+			wantStatus: map[string]string{"03": "c", "04": "w"},
+		},
+		{
+			name:       "without a stored solution",
+			entries:    map[string]int{"03": 4, "04": 5},
+			unsolved:   true,
+			wantStatus: map[string]string{"03": "c", "04": "w"},
+		},
+		{
+			name:          "completed",
+			wantCompleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puzzle := givensPuzzle(parseGrid(t, classicPuzzle, 9), classicLayout)
+			if !tt.unsolved {
+				storeSolution(&puzzle, solution)
+			}
+			entries := tt.entries
+			if entries == nil {
+				entries = map[string]int{}
+				for posKey, cell := range puzzle.Cells {
+					if cell.Value == 0 {
+						pos := cellIndex(posKey)
+						entries[posKey] = solution[pos/9][pos%9]
+					}
+				}
+			}
+			addEntries(puzzle, entries)
+
+			validated, ok, err := ValidateSolution(context.Background(), puzzle, SearchLimits{})
+			if err != nil || !ok {
+				t.Fatalf("ValidateSolution: ok %v, %v", ok, err)
+			}
+			for posKey, want := range tt.wantStatus {
+				if got := validated.Cells[posKey].Status; got != want {
+					t.Errorf("cell %s status %q, want %q", posKey, got, want)
+				}
+			}
+			if validated.Completed != tt.wantCompleted {
+				t.Errorf("completed %v, want %v", validated.Completed, tt.wantCompleted)
+			}
+		})
+	}
+}
+
+func TestValidateSolutionAmbiguous(t *testing.T) {
+	// Without its first two givens the puzzle has more than one solution
+	puzzle := givensPuzzle(parseGrid(t, "000070000"+classicPuzzle[9:], 9), classicLayout)
+	if _, _, err := ValidateSolution(context.Background(), puzzle, SearchLimits{}); !errors.Is(err, ErrMultipleSolutions) {
+		t.Errorf("ValidateSolution error %v, want %v", err, ErrMultipleSolutions)
+	}
+}
+
+func TestAttemptSolve(t *testing.T) {
+	puzzle := givensPuzzle(parseGrid(t, classicPuzzle, 9), classicLayout)
+	cells, solved, err := AttemptSolve(context.Background(), puzzle, SearchLimits{})
+	if err != nil || !solved {
+		t.Fatalf("AttemptSolve: solved %v, %v", solved, err)
+	}
+	if got := cellsToGrid(cells, 9); got != parseGrid(t, classicSolution, 9) {
+		t.Errorf("solved grid:\n%s", PrintGrid(got, 9))
+	}
+}
@@ -0,0 +1,87 @@
+package sudoku
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Symmetries the generator can give the clue pattern
+const (
+	SymmetryNone         = ""             // Clues removed one cell at a time
+	SymmetryRotational   = "rotational"   // 180° rotation about the centre
+	SymmetryDiagonal     = "diagonal"     // Reflection in the main diagonal
+	SymmetryMirror       = "mirror"       // Reflection in the middle column
+	SymmetryRotational90 = "rotational90" // 90° rotation about the centre
+)
+
+// symmetryTransforms maps each symmetry to the cell mappings generating it.
+// last is the index of the last row and column of the grid.
+var symmetryTransforms = map[string][]func(last, row, col int) (int, int){
+	SymmetryNone: nil,
+	SymmetryRotational: {
+		func(last, row, col int) (int, int) { return last - row, last - col },
+	},
+	SymmetryDiagonal: {
+		func(last, row, col int) (int, int) { return col, row },
+	},
+	SymmetryMirror: {
+		func(last, row, col int) (int, int) { return row, last - col },
+	},
+	SymmetryRotational90: {
+		func(last, row, col int) (int, int) { return col, last - row },
+	},
+}
+
+// ParseSymmetry validates a symmetry name
+func ParseSymmetry(symmetry string) (string, error) {
+	if _, ok := symmetryTransforms[symmetry]; !ok {
+		return "", fmt.Errorf("unknown symmetry %q", symmetry)
+	}
+	return symmetry, nil
+}
+
+// shuffledOrbits splits the board into the orbits of a symmetry, i.e. the
+// groups of cells that must be given or removed together, in random order.
+// Board positions without a cell, such as the gaps of a Samurai, are left out.
+func shuffledOrbits(symmetry string, lay *layout, rng *rand.Rand) [][][2]int {
+	transforms := symmetryTransforms[symmetry]
+	size := lay.width
+
+	var orbits [][][2]int
+	seen := make([]bool, size*size)
+	for cell := range seen {
+		if seen[cell] {
+			continue
+		}
+
+		// Apply the transforms until no new cells turn up
+		orbit := [][2]int{{cell / size, cell % size}}
+		seen[cell] = true
+		for i := 0; i < len(orbit); i++ {
+			for _, transform := range transforms {
+				row, col := transform(size-1, orbit[i][0], orbit[i][1])
+				if !seen[row*size+col] {
+					seen[row*size+col] = true
+					orbit = append(orbit, [2]int{row, col})
+				}
+			}
+		}
+
+		onBoard := orbit[:0]
+		for _, pos := range orbit {
+			if lay.cellAt(pos[0], pos[1]) >= 0 {
+				onBoard = append(onBoard, pos)
+			}
+		}
+		if len(onBoard) > 0 {
+			orbits = append(orbits, onBoard)
+		}
+	}
+
+	// This creates a random permutation of the orbits
+	for i := 0; i < len(orbits); i++ {
+		j := i + rng.Intn(len(orbits)-i)
+		orbits[i], orbits[j] = orbits[j], orbits[i]
+	}
+	return orbits
+}
@@ -2,18 +2,172 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/danjones/sudoku_dj/internal/models"
 )
 
-// SavePuzzle saves a puzzle to disk
+// ErrDuplicatePuzzle is returned by SaveUniquePuzzle when an earlier saved
+// puzzle has the same signature
+var ErrDuplicatePuzzle = errors.New("duplicate puzzle")
+
+// signatureIndex records the signature of every saved puzzle, so that saves
+// can look for duplicates without reading every puzzle file. It is built
+// from the puzzles directory on first use and kept up to date as puzzles are
+// written and deleted.
+type signatureIndex struct {
+	puzzles     map[string]indexedPuzzle       // By UUID
+	bySignature map[string]map[string]struct{} // UUIDs of the puzzles with each signature
+}
+
+// indexedPuzzle is what duplicate checks need to know about a saved puzzle
+type indexedPuzzle struct {
+	signature   string
+	created     time.Time
+	duplicateOf string
+}
+
+var (
+	indexMutex sync.Mutex
+	index      *signatureIndex
+)
+
+// puzzleIndex returns the signature index, building it if need be. The
+// caller must hold indexMutex.
+func puzzleIndex() *signatureIndex {
+	if index != nil {
+		return index
+	}
+	index = &signatureIndex{
+		puzzles:     make(map[string]indexedPuzzle),
+		bySignature: make(map[string]map[string]struct{}),
+	}
+	files, err := ioutil.ReadDir("puzzles")
+	if err != nil {
+		return index
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		if puzzle, err := LoadPuzzle(strings.TrimSuffix(file.Name(), ".json")); err == nil {
+			index.add(puzzle)
+		}
+	}
+	Log(LogLevelDebug, "Indexed the signatures of %d puzzles", len(index.puzzles))
+	return index
+}
+
+// add records a saved puzzle, replacing any earlier entry for its UUID
+func (idx *signatureIndex) add(puzzle models.Puzzle) {
+	idx.remove(puzzle.UUID)
+	idx.puzzles[puzzle.UUID] = indexedPuzzle{
+		signature:   puzzle.Signature,
+		created:     parseCreatedAt(puzzle),
+		duplicateOf: puzzle.DuplicateOf,
+	}
+	if puzzle.Signature == "" {
+		return
+	}
+	if idx.bySignature[puzzle.Signature] == nil {
+		idx.bySignature[puzzle.Signature] = make(map[string]struct{})
+	}
+	idx.bySignature[puzzle.Signature][puzzle.UUID] = struct{}{}
+}
+
+// remove forgets a deleted puzzle
+func (idx *signatureIndex) remove(uuid string) {
+	entry, ok := idx.puzzles[uuid]
+	if !ok {
+		return
+	}
+	delete(idx.puzzles, uuid)
+	if uuids := idx.bySignature[entry.signature]; uuids != nil {
+		delete(uuids, uuid)
+		if len(uuids) == 0 {
+			delete(idx.bySignature, entry.signature)
+		}
+	}
+}
+
+// duplicateOf returns the UUID of the earliest indexed puzzle created before
+// this one with the same signature, or "" if there is none
+func (idx *signatureIndex) duplicateOf(puzzle models.Puzzle) string {
+	created := parseCreatedAt(puzzle)
+	duplicate := ""
+	var duplicateCreated time.Time
+	for uuid := range idx.bySignature[puzzle.Signature] {
+		if uuid == puzzle.UUID {
+			continue
+		}
+		otherCreated := idx.puzzles[uuid].created
+		if !otherCreated.Before(created) && !(otherCreated.Equal(created) && uuid < puzzle.UUID) {
+			continue
+		}
+		if duplicate == "" || otherCreated.Before(duplicateCreated) ||
+			(otherCreated.Equal(duplicateCreated) && uuid < duplicate) {
+			duplicate, duplicateCreated = uuid, otherCreated
+		}
+	}
+	return duplicate
+}
+
+// SavePuzzle saves a puzzle to disk. A puzzle with the same signature as an
+// earlier saved puzzle is still saved, but flagged with DuplicateOf.
 func SavePuzzle(puzzle models.Puzzle) (models.Puzzle, error) {
+	puzzle.DuplicateOf = FindDuplicate(puzzle)
+	return writePuzzle(puzzle)
+}
+
+// SaveUniquePuzzle saves a puzzle to disk unless it has the same signature as
+// an earlier saved puzzle, in which case ErrDuplicatePuzzle is returned and
+// the puzzle's DuplicateOf names the earlier one
+func SaveUniquePuzzle(puzzle models.Puzzle) (models.Puzzle, error) {
+	puzzle.DuplicateOf = FindDuplicate(puzzle)
+	if puzzle.DuplicateOf != "" {
+		Log(LogLevelWarn, "Puzzle %s duplicates puzzle %s", puzzle.UUID, puzzle.DuplicateOf)
+		return puzzle, fmt.Errorf("%w of %s", ErrDuplicatePuzzle, puzzle.DuplicateOf)
+	}
+	return writePuzzle(puzzle)
+}
+
+// FindDuplicate returns the UUID of the earliest saved puzzle created before
+// this one with the same signature, or "" if there is none. Puzzles without a
+// signature have no duplicates. A puzzle saved before with the same
+// signature, such as one whose progress is being saved, keeps the duplicate
+// found then, unless that puzzle has since been deleted.
+func FindDuplicate(puzzle models.Puzzle) string {
+	if puzzle.Signature == "" {
+		return ""
+	}
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	idx := puzzleIndex()
+	if saved, ok := idx.puzzles[puzzle.UUID]; ok && saved.signature == puzzle.Signature {
+		if _, ok := idx.puzzles[saved.duplicateOf]; ok || saved.duplicateOf == "" {
+			return saved.duplicateOf
+		}
+	}
+	return idx.duplicateOf(puzzle)
+}
+
+// parseCreatedAt returns when a puzzle was created, or the zero time if that
+// isn't known
+func parseCreatedAt(puzzle models.Puzzle) time.Time {
+	created, _ := time.Parse(time.RFC3339, puzzle.CreatedAt)
+	return created
+}
+
+// writePuzzle writes a puzzle to its file
+func writePuzzle(puzzle models.Puzzle) (models.Puzzle, error) {
 	data, err := json.MarshalIndent(puzzle, "", "  ")
 	if err != nil {
 		Log(LogLevelError, "Error marshaling puzzle: %v", err)
@@ -31,12 +185,31 @@ func SavePuzzle(puzzle models.Puzzle) (models.Puzzle, error) {
 		return puzzle, fmt.Errorf("error writing puzzle file: %v", err)
 	}
 
+	indexMutex.Lock()
+	puzzleIndex().add(puzzle)
+	indexMutex.Unlock()
+
 	Log(LogLevelInfo, "Saved puzzle with UUID: %s", puzzle.UUID)
 	return puzzle, nil
 }
 
-// ListPuzzles returns a list of saved puzzles
-func ListPuzzles() ([]map[string]interface{}, error) {
+// rewritePuzzle writes back a puzzle whose stored fields were filled in,
+// keeping the file's modification date
+func rewritePuzzle(puzzle models.Puzzle, modTime time.Time) {
+	if _, err := writePuzzle(puzzle); err != nil {
+		return
+	}
+	filename := fmt.Sprintf("puzzles/%s.json", puzzle.UUID)
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		Log(LogLevelWarn, "Error restoring modification date of %s: %v", filename, err)
+	}
+}
+
+// ListPuzzles returns a list of saved puzzles. backfill, if given, is called
+// on each puzzle to fill in fields stored by later versions, and a puzzle it
+// reports changed is written back once. The file's modification date, which
+// the list is sorted by, is kept.
+func ListPuzzles(backfill func(puzzle *models.Puzzle) bool) ([]map[string]interface{}, error) {
 	files, err := ioutil.ReadDir("puzzles")
 	if err != nil {
 		Log(LogLevelError, "Error reading puzzles directory: %v", err)
@@ -60,39 +233,38 @@ func ListPuzzles() ([]map[string]interface{}, error) {
 		// Get create date from file
 		date := file.ModTime().Format(time.RFC3339)
 
-		// Load puzzle to get difficulty
+		// Load puzzle to get its stored difficulty and rating
 		puzzle, err := LoadPuzzle(uuid)
-		difficulty := 1 // Default difficulty
+		difficulty := 0
+		rating := 0.0
+		tier := ""
+		size := 9
+		signature := ""
+		duplicateOf := ""
+		if err == nil && puzzle.UUID == uuid && backfill != nil && backfill(&puzzle) {
+			rewritePuzzle(puzzle, file.ModTime())
+		}
 		if err == nil {
-			// Use the stored difficulty value instead of recalculating
 			difficulty = puzzle.Difficulty
-
-			// If difficulty is not set (0 or invalid), calculate it based on filled cells
-			if difficulty < 1 || difficulty > 9 {
-				// Count the number of filled cells to estimate difficulty
-				filledCells := 0
-				for _, cell := range puzzle.Cells {
-					if cell.Status == "s" {
-						filledCells++
-					}
-				}
-
-				// More filled cells = easier puzzle (lower difficulty)
-				// Use floating point division for more accurate difficulty calculation
-				difficulty = 10 - int(float64(filledCells)/9.0)
-				if difficulty < 1 {
-					difficulty = 1
-				} else if difficulty > 9 {
-					difficulty = 9
-				}
+			if puzzle.Size != 0 {
+				size = puzzle.Size
 			}
+			rating = puzzle.Rating
+			tier = puzzle.Tier
+			signature = puzzle.Signature
+			duplicateOf = puzzle.DuplicateOf
 		}
 
 		puzzles = append(puzzles, map[string]interface{}{
-			"uuid":       uuid,
-			"date":       date,
-			"difficulty": difficulty,
-			"shortId":    uuid[:8], // Safe to slice now
+			"uuid":        uuid,
+			"date":        date,
+			"difficulty":  difficulty,
+			"rating":      rating,
+			"tier":        tier,
+			"size":        size,
+			"signature":   signature,
+			"duplicateOf": duplicateOf,
+			"shortId":     uuid[:8], // Safe to slice now
 		})
 	}
 
@@ -107,6 +279,30 @@ func ListPuzzles() ([]map[string]interface{}, error) {
 	return puzzles, nil
 }
 
+// GroupPuzzles groups a puzzle list by the value of key, e.g. "signature",
+// keeping the order of the list. Each group holds the value and its puzzles;
+// puzzles with no value get a group each.
+func GroupPuzzles(puzzles []map[string]interface{}, key string) []map[string]interface{} {
+	var groups []map[string]interface{}
+	byValue := make(map[interface{}]map[string]interface{})
+	for _, entry := range puzzles {
+		value := entry[key]
+		group, ok := byValue[value]
+		if !ok || value == nil || value == "" {
+			group = map[string]interface{}{
+				key:       value,
+				"puzzles": []map[string]interface{}{},
+			}
+			groups = append(groups, group)
+			byValue[value] = group
+		}
+		group["puzzles"] = append(group["puzzles"].([]map[string]interface{}), entry)
+	}
+
+	Log(LogLevelDebug, "Grouped %d puzzles into %d groups by %s", len(puzzles), len(groups), key)
+	return groups
+}
+
 // LoadPuzzle loads a puzzle from disk by UUID
 func LoadPuzzle(uuid string) (models.Puzzle, error) {
 	var puzzle models.Puzzle
@@ -143,6 +339,10 @@ func DeletePuzzle(uuid string) error {
 		return fmt.Errorf("error deleting puzzle file: %v", err)
 	}
 
+	indexMutex.Lock()
+	puzzleIndex().remove(uuid)
+	indexMutex.Unlock()
+
 	Log(LogLevelInfo, "Deleted puzzle with UUID: %s", uuid)
 	return nil
 }
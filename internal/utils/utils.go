@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 // ParseDifficulty parses the difficulty level from a string
@@ -15,3 +17,75 @@ func ParseDifficulty(difficultyStr string) int {
 	}
 	return difficulty
 }
+
+// ParseHintLevel parses the hint level (1-3) from a string
+func ParseHintLevel(levelStr string) int {
+	level, err := strconv.Atoi(levelStr)
+	if err != nil || level < 1 || level > 3 {
+		return 3
+	}
+	return level
+}
+
+// ParseSolutionCount parses how many solutions to list, returning 0 (the
+// default) if none or an invalid count is given
+func ParseSolutionCount(countStr string) int {
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 {
+		return 0
+	}
+	return count
+}
+
+// ParseFlag parses an optional boolean query parameter, defaulting to false
+func ParseFlag(flagStr string) bool {
+	flag, err := strconv.ParseBool(flagStr)
+	return err == nil && flag
+}
+
+// ParseSeed parses an optional generator seed, returning 0 if none is given
+func ParseSeed(seedStr string) (int64, error) {
+	if seedStr == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(seedStr, 10, 64)
+}
+
+// ParseClueRange parses a clue count, either exact ("24") or a range
+// ("22-24"), returning zeros if none is given. Counts must lie between
+// fewest and most.
+func ParseClueRange(cluesStr string, fewest, most int) (int, int, error) {
+	if cluesStr == "" {
+		return 0, 0, nil
+	}
+
+	minStr, maxStr, isRange := strings.Cut(cluesStr, "-")
+	if !isRange {
+		maxStr = minStr
+	}
+	minClues, err := strconv.Atoi(minStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid clue count %q", cluesStr)
+	}
+	maxClues, err := strconv.Atoi(maxStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid clue count %q", cluesStr)
+	}
+	if minClues < fewest || maxClues > most || minClues > maxClues {
+		return 0, 0, fmt.Errorf("clue count %q must lie between %d and %d", cluesStr, fewest, most)
+	}
+	return minClues, maxClues, nil
+}
+
+// ParseMaxClues parses an optional maximum clue count, returning 0 if none
+// is given. The count must lie between fewest and most.
+func ParseMaxClues(maxStr string, fewest, most int) (int, error) {
+	if maxStr == "" {
+		return 0, nil
+	}
+	maxClues, err := strconv.Atoi(maxStr)
+	if err != nil || maxClues < fewest || maxClues > most {
+		return 0, fmt.Errorf("maximum clue count %q must lie between %d and %d", maxStr, fewest, most)
+	}
+	return maxClues, nil
+}
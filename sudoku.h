@@ -4,7 +4,40 @@
 
 #include <stdbool.h>
 
+// Extra regions that must also hold every digit once, combined as flags
+#define SUDOKU_REGION_DIAGONAL 1 // Both main diagonals (Sudoku X)
+#define SUDOKU_REGION_WINDOKU  2 // The four 3*3 windows of Windoku/Hyper Sudoku
+
+// Chess rules forbidding equal digits a move apart, combined with the region flags
+#define SUDOKU_RULE_ANTI_KNIGHT 4 // No equal digits a knight's move apart
+#define SUDOKU_RULE_ANTI_KING   8 // No equal digits a king's move apart
+
 bool solve_sudoku(int *board);
 int count_solutions(int *board);
+bool solve_sudoku_regions(int *board, int regions);
+int count_solutions_regions(int *board, int regions);
+
+// A search that can be run a slice at a time, so that the caller can stop it
+// between slices. Empty cells are filled in row-major order.
+typedef struct sudoku_search {
+    int board[81];
+    int solution[81];     // The first solution found
+    int empty[81];        // Cells that were empty, in the order they are filled
+    int empty_count;
+    int depth;            // Index into empty of the cell being filled
+    int regions;
+    int solutions;        // Solutions found so far
+    long long nodes;      // Search nodes visited so far
+    bool finished;        // Every solution has been found
+} sudoku_search;
+
+void sudoku_search_init(sudoku_search *search, const int *board, int regions);
+// Continues a search until it has found max_solutions solutions in all (0 for
+// no limit), visited max_nodes more nodes (0 for no limit) or finished.
+// Returns the number of solutions found so far.
+int sudoku_search_run(sudoku_search *search, int max_solutions, long long max_nodes);
+// Counts solutions up to max_solutions (0 for no limit), giving up with -1
+// after max_nodes search nodes (0 for no limit)
+int count_solutions_limit(int *board, int regions, int max_solutions, long long max_nodes);
 
 #endif
\ No newline at end of file